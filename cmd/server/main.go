@@ -1,87 +1,108 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 
+	"uploader/internal/auth"
 	"uploader/internal/config"
 	"uploader/internal/handlers"
+	"uploader/internal/jobs"
+	"uploader/internal/mediaurl"
 	"uploader/internal/middleware"
+	"uploader/internal/services"
+	"uploader/internal/storage"
+	"uploader/internal/tokens"
 
 	"github.com/go-chi/chi/v5"
 )
 
 func main() {
-	// --- Enhanced Debugging ---
-	cwd, err := os.Getwd()
+	// Settings come from uploader.yaml (or -config), UPLOADER_* variables and flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatalf("FATAL: Failed to get current working directory: %v", err)
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
-	log.Printf("DEBUG: Current Working Directory (CWD): %s", cwd)
-
-	// Explicitly define the relative path we expect to work
-	relativePath := "creds.json"
-	log.Printf("DEBUG: Relative path being checked: %s", relativePath)
+	log.Printf("Loaded configuration: public URL %s, credentials from '%s'", cfg.BaseURL, cfg.CredentialsFile)
 
-	// Resolve the absolute path based on the CWD
-	absPath, err := filepath.Abs(relativePath)
+	// Tokens are encrypted at rest and refreshed automatically when a platform needs them
+	tokenKey, err := tokens.ParseKey(cfg.TokenKey)
 	if err != nil {
-		log.Printf("WARN: Could not resolve absolute path for '%s': %v", relativePath, err)
-		absPath = "[unknown]" // Assign a placeholder if Abs fails
+		log.Fatalf("Invalid token encryption key (set UPLOADER_TOKEN_KEY, e.g. from 'openssl rand -base64 32'): %v", err)
 	}
-	log.Printf("DEBUG: Expected absolute path: %s", absPath)
-
-	// Use os.Stat to check file existence and permissions directly
-	log.Printf("DEBUG: Running os.Stat on relative path '%s'...", relativePath)
-	fileInfo, statErr := os.Stat(relativePath)
-
-	if statErr != nil {
-		log.Printf("DEBUG: os.Stat FAILED: %v", statErr) // Log the specific error from os.Stat
-
-		// Check the type of error
-		if os.IsNotExist(statErr) {
-			log.Printf("DEBUG: os.Stat confirms file does NOT exist at relative path '%s'.", relativePath)
-		} else if os.IsPermission(statErr) {
-			log.Printf("DEBUG: os.Stat confirms PERMISSION DENIED for relative path '%s'.", relativePath)
-		} else {
-			log.Printf("DEBUG: os.Stat failed with an unexpected error type for relative path '%s'.", relativePath)
-		}
-
-		// Also try stating the absolute path IF we could resolve it
-		if absPath != "[unknown]" {
-			log.Printf("DEBUG: Running os.Stat on absolute path '%s'...", absPath)
-			_, statErrAbs := os.Stat(absPath)
-			if statErrAbs != nil {
-				log.Printf("DEBUG: os.Stat on absolute path FAILED: %v", statErrAbs)
-			} else {
-				log.Printf("DEBUG: os.Stat on absolute path SUCCEEDED.") // This would be very strange if relative failed
-			}
+	tokenStore, err := tokens.NewFileStore(cfg.TokensDir, tokenKey)
+	if err != nil {
+		log.Fatalf("Failed to open token store: %v", err)
+	}
+	tokenManager := tokens.NewManager(cfg, tokenStore)
+
+	// Platforms that fetch videos themselves get signed, expiring URLs to them
+	mediaURLs := mediaurl.NewSigner(cfg.BaseURL, tokenKey)
+
+	// Register the upload destinations that have credentials configured
+	platforms := []struct {
+		service  services.Platform
+		login    http.HandlerFunc
+		callback http.HandlerFunc
+	}{
+		{services.NewYouTube(tokenManager), handlers.HandleYoutubeLogin, handlers.HandleYoutubeCallback},
+		{services.NewInstagram(tokenManager, mediaURLs), handlers.HandleInstagramLogin, handlers.HandleInstagramCallback},
+		{services.NewTikTok(tokenManager), handlers.HandleTikTokLogin, handlers.HandleTikTokCallback},
+	}
+	for _, p := range platforms {
+		if !cfg.Enabled(p.service.Name()) {
+			log.Printf("Platform %s: disabled (no credentials in '%s')", p.service.DisplayName(), cfg.CredentialsFile)
+			continue
 		}
-
-	} else {
-		// This case should NOT happen based on your error, but good to have
-		log.Printf("DEBUG: os.Stat SUCCEEDED for relative path '%s'. File size: %d, Mode: %s", relativePath, fileInfo.Size(), fileInfo.Mode())
+		services.Register(p.service)
+		log.Printf("Platform %s: enabled, redirect URL %s", p.service.DisplayName(), cfg.CallbackURL(p.service.Name()))
+	}
+	if len(services.Platforms()) == 0 {
+		log.Printf("WARN: No platform has credentials in '%s', nothing can be uploaded", cfg.CredentialsFile)
 	}
-	// --- End Enhanced Debugging ---
 
-	// Now, attempt the load again (which we expect to fail based on prior runs)
-	log.Printf("Attempting to load configuration directly from: %s", relativePath)
-	_, err = config.Load(relativePath) // Use the relativePath variable
+	// Open the upload history and accounts database
+	store, err := storage.Open(cfg.DatabasePath)
 	if err != nil {
-		// Log the final failure reason
-		log.Fatalf("Failed to load configuration from '%s': %v", relativePath, err)
+		log.Fatalf("Failed to open upload history: %v", err)
+	}
+	defer store.Close()
+	authService := auth.NewService(store)
+
+	// Start the background upload workers, resuming jobs a restart interrupted
+	jobManager, err := jobs.NewManager(context.Background(), store, jobs.Options{
+		Workers:    cfg.Upload.Workers,
+		QueueSize:  cfg.Upload.QueueSize,
+		StagingDir: cfg.StagingDir,
+		Retention:  cfg.Upload.Retention(),
+	})
+	if err != nil {
+		log.Fatalf("Failed to start upload workers: %v", err)
+	}
+	err = handlers.Setup(handlers.Dependencies{
+		Auth:        authService,
+		OAuthStates: auth.NewStateStore(),
+		Jobs:        jobManager,
+		Tokens:      tokenManager,
+		MediaURLs:   mediaURLs,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up handlers: %v", err)
 	}
-
-	// Code below here likely won't be reached
-	log.Println("Successfully loaded configuration.")
 
 	// Create a new router
 	r := chi.NewRouter()
 
 	// Apply middleware
 	r.Use(middleware.Logger)
+	r.Use(middleware.LoadUser(authService))
 
 	// Register routes
 	r.Get("/", handlers.ShowHomePage)
@@ -91,23 +112,53 @@ func main() {
 	r.Get("/privacy", handlers.ShowPrivacyPage)
 	r.Get("/data-removal", handlers.ShowDataRemovalPage)
 
-	// Authentication routes
-	r.Get("/login/youtube", handlers.HandleYoutubeLogin)
-	r.Get("/login/instagram", handlers.HandleInstagramLogin)
-	r.Get("/login/tiktok", handlers.HandleTikTokLogin)
-	r.Get("/callback/youtube", handlers.HandleYoutubeCallback)
-	r.Get("/callback/instagram", handlers.HandleInstagramCallback)
-	r.Get("/callback/tiktok", handlers.HandleTikTokCallback)
-
-	// Upload routes
-	r.Get("/upload", handlers.ShowUploadPage)
-	r.Post("/upload", handlers.HandleUpload)
+	// Account routes
+	r.Get("/signin", handlers.ShowSignInPage)
+	r.Post("/signin", handlers.HandleSignIn)
+	r.Post("/signout", handlers.HandleSignOut)
+	r.Get("/register", handlers.ShowRegisterPage)
+	r.Post("/register", handlers.HandleRegister)
+
+	// Staged videos, for platforms that download them; requests are signed
+	r.Get(mediaurl.Prefix+"{name}", handlers.HandleMedia)
+	r.Head(mediaurl.Prefix+"{name}", handlers.HandleMedia)
+
+	// Everything below acts on the signed-in user's accounts and uploads
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.RequireUser)
+
+		// Authentication routes, only for enabled platforms
+		for _, p := range platforms {
+			if _, ok := services.Lookup(p.service.Name()); ok {
+				r.Get("/login/"+p.service.Name(), p.login)
+				r.Get("/callback/"+p.service.Name(), p.callback)
+			}
+		}
+		r.Get("/connections", handlers.ShowConnectionsPage)
+		r.Delete("/connections/{platform}/{account}", handlers.HandleDisconnect)
+
+		// Upload routes
+		r.Get("/upload", handlers.ShowUploadPage)
+		r.Post("/upload", handlers.HandleUpload)
+		r.Get("/jobs/{id}", handlers.HandleJobStatus)
+		r.Get("/jobs/{id}/events", handlers.HandleJobEvents)
+		r.Post("/jobs/{id}/cancel", handlers.HandleCancelJob)
+		r.Post("/jobs/{id}/retry", handlers.HandleRetryJob)
+		if _, ok := services.Lookup("youtube"); ok {
+			r.Get("/youtube/categories", handlers.HandleYouTubeCategories)
+			r.Get("/youtube/playlists", handlers.HandleYouTubePlaylists)
+		}
+		if _, ok := services.Lookup("tiktok"); ok {
+			r.Get("/tiktok/options", handlers.HandleTikTokOptions)
+		}
+		r.Get("/history", handlers.ShowHistoryPage)
+	})
 
 	// Serve static files
-	fileServer := http.FileServer(http.Dir("./static"))
+	fileServer := http.FileServer(http.Dir(cfg.StaticDir))
 	r.Handle("/static/*", http.StripPrefix("/static/", fileServer))
 
 	// Start the server
-	log.Println("Server is running on :3000")
-	http.ListenAndServe(":3000", r)
+	log.Printf("Server is running on %s", cfg.ListenAddr)
+	log.Fatal(http.ListenAndServe(cfg.ListenAddr, r))
 }
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"uploader/internal/models"
+	"uploader/internal/storage"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionCookie is the name of the cookie holding the session token
+const SessionCookie = "uploader_session"
+
+// SessionTTL is how long a sign-in lasts
+const SessionTTL = 30 * 24 * time.Hour
+
+// MinPasswordLength is the shortest password accepted at registration
+const MinPasswordLength = 8
+
+var (
+	// ErrInvalidCredentials is returned when the username or password is wrong
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrNoSession is returned when a session token is unknown or expired
+	ErrNoSession = errors.New("not signed in")
+
+	// dummyHash is compared against when a username doesn't exist
+	dummyHash, _ = bcrypt.GenerateFromPassword([]byte("uploader"), bcrypt.DefaultCost)
+)
+
+// Service manages local user accounts and their sessions
+type Service struct {
+	store *storage.Store
+}
+
+// NewService returns an account service backed by store
+func NewService(store *storage.Store) *Service {
+	return &Service{store: store}
+}
+
+// Register creates a new account with a bcrypt hashed password
+func (s *Service) Register(username, password string) (*models.User, error) {
+	return s.register(username, password, s.store.CreateUser)
+}
+
+// RegisterFirst creates the initial account, which anyone may do. It fails
+// with storage.ErrUsersExist if another account got there first.
+func (s *Service) RegisterFirst(username, password string) (*models.User, error) {
+	return s.register(username, password, s.store.CreateFirstUser)
+}
+
+// register validates the details and saves the account with create
+func (s *Service) register(username, password string, create func(models.User) error) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username is required")
+	}
+	if len(password) < MinPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	id, err := randomString(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate user ID: %w", err)
+	}
+
+	user := models.User{
+		ID:           id,
+		Username:     username,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}
+	if err := create(user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Authenticate checks a username and password and returns the matching user
+func (s *Service) Authenticate(username, password string) (*models.User, error) {
+	user, err := s.store.GetUserByUsername(strings.TrimSpace(username))
+	if errors.Is(err, storage.ErrNotFound) {
+		// Hash anyway so unknown usernames take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
+
+// HasUsers reports whether any account has been registered yet
+func (s *Service) HasUsers() (bool, error) {
+	n, err := s.store.CountUsers()
+	return n > 0, err
+}
+
+// CreateSession starts a session for the user and returns its token.
+// Only a hash of the token is stored.
+func (s *Service) CreateSession(userID string) (string, time.Time, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate session token: %w", err)
+	}
+
+	expires := time.Now().Add(SessionTTL)
+	err = s.store.SaveSession(hashToken(token), models.Session{UserID: userID, ExpiresAt: expires})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// UserForSession returns the user a session token belongs to
+func (s *Service) UserForSession(token string) (*models.User, error) {
+	session, err := s.store.GetSession(hashToken(token))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		s.store.DeleteSession(hashToken(token))
+		return nil, ErrNoSession
+	}
+
+	user, err := s.store.GetUser(session.UserID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DeleteSession ends a session
+func (s *Service) DeleteSession(token string) error {
+	return s.store.DeleteSession(hashToken(token))
+}
+
+type userKey struct{}
+
+// WithUser returns a context carrying the signed-in user
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the signed-in user from the context, or nil
+func UserFrom(ctx context.Context) *models.User {
+	user, _ := ctx.Value(userKey{}).(*models.User)
+	return user
+}
+
+// hashToken returns the form a session token is stored in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes encoded as URL-safe base64
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// OAuthStateTTL is how long a platform login may take before its state expires
+const OAuthStateTTL = 10 * time.Minute
+
+// ErrInvalidState is returned when an OAuth callback's state is unknown,
+// expired, already used or belongs to another login
+var ErrInvalidState = errors.New("invalid or expired OAuth state")
+
+// pendingLogin is a platform login that has been started but not completed
+type pendingLogin struct {
+	userID   string
+	platform string
+	verifier string // PKCE code verifier
+	expires  time.Time
+}
+
+// StateStore hands out single-use OAuth state values, each bound to the
+// user and platform that started the login and to a PKCE code verifier.
+// Verifiers never leave the server.
+type StateStore struct {
+	mu      sync.Mutex
+	pending map[string]pendingLogin // Keyed by state
+}
+
+// NewStateStore returns an empty state store
+func NewStateStore() *StateStore {
+	return &StateStore{pending: make(map[string]pendingLogin)}
+}
+
+// Begin starts a login and returns its state and PKCE code verifier
+func (s *StateStore) Begin(userID, platform string) (state, verifier string, err error) {
+	state, err = randomString(32)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate OAuth state: %w", err)
+	}
+	verifier = oauth2.GenerateVerifier()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop logins that were abandoned
+	now := time.Now()
+	for key, login := range s.pending {
+		if now.After(login.expires) {
+			delete(s.pending, key)
+		}
+	}
+
+	s.pending[state] = pendingLogin{
+		userID:   userID,
+		platform: platform,
+		verifier: verifier,
+		expires:  now.Add(OAuthStateTTL),
+	}
+	return state, verifier, nil
+}
+
+// Consume checks the state returned to a callback against the one saved in
+// the browser's cookie and returns the login's PKCE code verifier. A state
+// can only be consumed once.
+func (s *StateStore) Consume(state, cookieState, userID, platform string) (string, error) {
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		return "", ErrInvalidState
+	}
+
+	s.mu.Lock()
+	login, ok := s.pending[state]
+	delete(s.pending, state)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(login.expires) || login.userID != userID || login.platform != platform {
+		return "", ErrInvalidState
+	}
+	return login.verifier, nil
+}
@@ -2,13 +2,19 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"net/url"
 	"os"
-
-	// Remove "sync" import if no longer needed elsewhere
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"gopkg.in/yaml.v3"
 )
 
 type Credentials struct {
@@ -24,16 +30,116 @@ type Credentials struct {
 		ClientKey    string `json:"client_key"`
 		ClientSecret string `json:"client_secret"`
 	} `json:"tiktok"`
+	// TokenKey is the base64 encoded AES-256 key used to encrypt saved tokens.
+	// The token_key setting and UPLOADER_TOKEN_KEY environment variable take precedence.
+	TokenKey string `json:"token_key"`
 }
 
-// Config holds all configuration for the application
+// Config holds all configuration for the application.
+// Settings are read from an optional YAML or JSON file, then overridden by
+// UPLOADER_* environment variables and finally by command line flags.
 type Config struct {
-	Credentials          *Credentials
-	YouTubeOAuthConfig   *oauth2.Config
-	InstagramOAuthConfig *oauth2.Config
-	TikTokOAuthConfig    *oauth2.Config
-	RandomState          string
-	TemplatesDir         string
+	BaseURL         string `json:"base_url"`         // Public URL of the server; OAuth redirect URLs are derived from it
+	ListenAddr      string `json:"listen_addr"`      // Address the HTTP server listens on
+	CredentialsFile string `json:"credentials_file"` // JSON file with the platform client IDs and secrets
+	TemplatesDir    string `json:"templates_dir"`
+	StaticDir       string `json:"static_dir"`
+	DatabasePath    string `json:"database_path"` // BoltDB file with upload history and accounts
+	TokensDir       string `json:"tokens_dir"`    // Directory of encrypted platform tokens
+	TokenKey        string `json:"token_key"`     // Base64 encoded key for encrypting saved tokens
+	StagingDir      string `json:"staging_dir"`   // Directory of videos waiting to be uploaded and their resume state
+
+	Upload    UploadConfig    `json:"upload"`
+	YouTube   YouTubeConfig   `json:"youtube"`
+	Instagram InstagramConfig `json:"instagram"`
+	TikTok    TikTokConfig    `json:"tiktok"`
+
+	// Derived from the settings above when the configuration is loaded
+	Credentials          *Credentials   `json:"-"`
+	YouTubeOAuthConfig   *oauth2.Config `json:"-"`
+	InstagramOAuthConfig *oauth2.Config `json:"-"`
+	TikTokOAuthConfig    *oauth2.Config `json:"-"`
+}
+
+// UploadConfig limits the size and number of uploads
+type UploadConfig struct {
+	MaxSizeMB int64 `json:"max_size_mb"` // Largest accepted video; 0 means no limit
+	Workers   int   `json:"workers"`     // Number of jobs uploaded concurrently
+	QueueSize int   `json:"queue_size"`  // Number of jobs that may wait for a free worker
+
+	// RetainHours is how long the video of a job with failed uploads is
+	// kept so they can be retried; 0 deletes it as soon as the job ends
+	RetainHours int `json:"retain_hours"`
+}
+
+// Retention returns how long videos with failed uploads are kept
+func (u UploadConfig) Retention() time.Duration {
+	return time.Duration(u.RetainHours) * time.Hour
+}
+
+// MaxBytes returns the largest accepted upload in bytes, or 0 for no limit
+func (u UploadConfig) MaxBytes() int64 {
+	return u.MaxSizeMB << 20
+}
+
+// YouTubeConfig holds the YouTube scopes and upload defaults
+type YouTubeConfig struct {
+	Scopes        []string `json:"scopes"`
+	PrivacyStatus string   `json:"privacy_status"` // private, unlisted or public
+	CategoryID    string   `json:"category_id"`
+	RegionCode    string   `json:"region_code"` // Country whose video categories are offered, e.g. US
+
+	// ShortsMaxSeconds is the longest video YouTube treats as a Short. The
+	// default of 180 follows YouTube's limit since October 2024, which was 60
+	// before.
+	ShortsMaxSeconds int `json:"shorts_max_seconds"`
+}
+
+// ShortsMaxDuration returns the longest video YouTube treats as a Short
+func (y YouTubeConfig) ShortsMaxDuration() time.Duration {
+	return time.Duration(y.ShortsMaxSeconds) * time.Second
+}
+
+// InstagramConfig holds the Instagram scopes and upload defaults
+type InstagramConfig struct {
+	Scopes      []string `json:"scopes"`
+	ShareToFeed bool     `json:"share_to_feed"` // Also show reels in the profile feed
+	PollConfig           // How long to wait for Instagram to process a reel
+}
+
+// PollConfig controls how a platform is asked whether an upload has
+// finished processing
+type PollConfig struct {
+	PollIntervalSeconds int `json:"poll_interval_seconds"` // Wait between status checks
+	PollTimeoutSeconds  int `json:"poll_timeout_seconds"`  // Give up after this long
+}
+
+// PollInterval returns the wait between status checks
+func (p PollConfig) PollInterval() time.Duration {
+	return time.Duration(p.PollIntervalSeconds) * time.Second
+}
+
+// PollTimeout returns how long to wait for processing to finish
+func (p PollConfig) PollTimeout() time.Duration {
+	return time.Duration(p.PollTimeoutSeconds) * time.Second
+}
+
+// validate checks that the platform is polled at a sane rate
+func (p PollConfig) validate(platform string) error {
+	if p.PollIntervalSeconds < 1 {
+		return fmt.Errorf("%s poll_interval_seconds must be at least 1", platform)
+	}
+	if p.PollTimeoutSeconds < p.PollIntervalSeconds {
+		return fmt.Errorf("%s poll_timeout_seconds must not be less than poll_interval_seconds", platform)
+	}
+	return nil
+}
+
+// TikTokConfig holds the TikTok scopes and upload defaults
+type TikTokConfig struct {
+	Scopes       []string `json:"scopes"`
+	PrivacyLevel string   `json:"privacy_level"` // e.g. SELF_ONLY or PUBLIC_TO_EVERYONE
+	PollConfig            // How long to wait for TikTok to publish a post
 }
 
 var (
@@ -42,49 +148,159 @@ var (
 	globalConfig *Config
 )
 
-// Load loads configuration from a JSON file and initializes OAuth configs.
-// It now potentially updates the global config on each successful call.
-func Load(filename string) (*Config, error) {
-	creds, err := loadCredentials(filename)
+// defaultConfigFiles are looked for in the working directory when no
+// config file is given
+var defaultConfigFiles = []string{"uploader.yaml", "uploader.yml", "uploader.json"}
+
+// defaults returns the settings used when nothing else is configured
+func defaults() *Config {
+	return &Config{
+		BaseURL:         "http://localhost:3000",
+		ListenAddr:      ":3000",
+		CredentialsFile: "creds.json",
+		TemplatesDir:    "templates",
+		StaticDir:       "static",
+		DatabasePath:    "uploader.db",
+		TokensDir:       "tokens",
+		StagingDir:      "staging",
+		Upload: UploadConfig{
+			MaxSizeMB:   4096,
+			Workers:     2,
+			QueueSize:   16,
+			RetainHours: 24,
+		},
+		YouTube: YouTubeConfig{
+			Scopes: []string{
+				"https://www.googleapis.com/auth/youtube.upload",
+				"https://www.googleapis.com/auth/youtube.readonly",  // Channel ID and title of the connected account
+				"https://www.googleapis.com/auth/youtube.force-ssl", // Playlists and captions
+			},
+			PrivacyStatus: "private",
+			CategoryID:    "22", // People & Blogs
+			RegionCode:    "US",
+
+			ShortsMaxSeconds: 180,
+		},
+		Instagram: InstagramConfig{
+			// Instagram API with Instagram Login; the retired Basic Display
+			// scopes cannot publish Reels
+			Scopes:      []string{"instagram_business_basic", "instagram_business_content_publish"},
+			ShareToFeed: true,
+			PollConfig:  PollConfig{PollIntervalSeconds: 10, PollTimeoutSeconds: 600},
+		},
+		TikTok: TikTokConfig{
+			Scopes:       []string{"user.info.basic", "video.upload", "video.publish"},
+			PrivacyLevel: "SELF_ONLY", // Start with private visibility
+			PollConfig:   PollConfig{PollIntervalSeconds: 5, PollTimeoutSeconds: 600},
+		},
+	}
+}
+
+// option is a setting that can be overridden by an environment variable and a flag
+type option struct {
+	flag  string
+	env   string
+	usage string
+	field func(c *Config) interface{} // Pointer to the setting: *string, *int or *int64
+}
+
+var options = []option{
+	{"base-url", "UPLOADER_BASE_URL", "public URL of the server, used for OAuth redirect URLs", func(c *Config) interface{} { return &c.BaseURL }},
+	{"listen", "UPLOADER_LISTEN_ADDR", "address to listen on", func(c *Config) interface{} { return &c.ListenAddr }},
+	{"credentials", "UPLOADER_CREDENTIALS", "platform credentials file", func(c *Config) interface{} { return &c.CredentialsFile }},
+	{"templates", "UPLOADER_TEMPLATES_DIR", "templates directory", func(c *Config) interface{} { return &c.TemplatesDir }},
+	{"static", "UPLOADER_STATIC_DIR", "static files directory", func(c *Config) interface{} { return &c.StaticDir }},
+	{"database", "UPLOADER_DATABASE", "upload history and accounts database", func(c *Config) interface{} { return &c.DatabasePath }},
+	{"tokens-dir", "UPLOADER_TOKENS_DIR", "directory of encrypted platform tokens", func(c *Config) interface{} { return &c.TokensDir }},
+	{"token-key", "UPLOADER_TOKEN_KEY", "base64 encoded 32 byte token encryption key", func(c *Config) interface{} { return &c.TokenKey }},
+	{"staging-dir", "UPLOADER_STAGING_DIR", "directory of videos waiting to be uploaded", func(c *Config) interface{} { return &c.StagingDir }},
+	{"max-upload-mb", "UPLOADER_MAX_UPLOAD_MB", "largest accepted video in MB, 0 for no limit", func(c *Config) interface{} { return &c.Upload.MaxSizeMB }},
+	{"workers", "UPLOADER_WORKERS", "number of jobs uploaded concurrently", func(c *Config) interface{} { return &c.Upload.Workers }},
+	{"queue-size", "UPLOADER_QUEUE_SIZE", "number of jobs that may wait for a free worker", func(c *Config) interface{} { return &c.Upload.QueueSize }},
+	{"retain-hours", "UPLOADER_RETAIN_HOURS", "hours to keep videos with failed uploads for retrying, 0 to delete them straight away", func(c *Config) interface{} { return &c.Upload.RetainHours }},
+}
+
+// Load builds the configuration from defaults, the config file, environment
+// variables and the command line arguments (without the program name), in
+// increasing order of precedence, and loads the platform credentials.
+func Load(args []string) (*Config, error) {
+	cfg := defaults()
+
+	// Flags are parsed first to find the config file but applied last
+	fs := flag.NewFlagSet("uploader", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("UPLOADER_CONFIG"), "YAML or JSON config file (env UPLOADER_CONFIG)")
+	flagValues := make(map[string]string)
+	for _, opt := range options {
+		name := opt.flag
+		fs.Func(name, fmt.Sprintf("%s (env %s)", opt.usage, opt.env), func(value string) error {
+			flagValues[name] = value
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := loadFile(cfg, *configFile); err != nil {
+		return nil, err
+	}
+
+	for _, opt := range options {
+		if value := os.Getenv(opt.env); value != "" {
+			if err := set(opt.field(cfg), value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", opt.env, err)
+			}
+		}
+	}
+	for _, opt := range options {
+		if value, ok := flagValues[opt.flag]; ok {
+			if err := set(opt.field(cfg), value); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %w", opt.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	creds, err := loadCredentials(cfg.CredentialsFile)
 	if err != nil {
 		// Return error immediately, don't try to use partially loaded creds
 		return nil, err
 	}
+	cfg.Credentials = creds
+	if cfg.TokenKey == "" {
+		cfg.TokenKey = creds.TokenKey
+	}
 
-	// Create a new config instance based on the loaded creds
-	cfg := &Config{
-		Credentials: creds,
-		YouTubeOAuthConfig: &oauth2.Config{
-			RedirectURL:  "http://localhost:3000/callback/youtube",
-			ClientID:     creds.YouTube.ClientID,
-			ClientSecret: creds.YouTube.ClientSecret,
-			Scopes:       []string{"https://www.googleapis.com/auth/youtube.upload"},
-			Endpoint:     google.Endpoint,
-		},
-		InstagramOAuthConfig: &oauth2.Config{
-			RedirectURL:  "http://localhost:3000/callback/instagram",
-			ClientID:     creds.Instagram.ClientID,
-			ClientSecret: creds.Instagram.ClientSecret,
-			// Update scopes to match what's configured in FB dev portal
-			Scopes: []string{"user_profile", "user_media"},
-			Endpoint: oauth2.Endpoint{
-				// Update to use the Graph API endpoints
-				AuthURL:  "https://api.instagram.com/oauth/authorize",
-				TokenURL: "https://api.instagram.com/oauth/access_token",
-			},
+	cfg.YouTubeOAuthConfig = &oauth2.Config{
+		RedirectURL:  cfg.CallbackURL("youtube"),
+		ClientID:     creds.YouTube.ClientID,
+		ClientSecret: creds.YouTube.ClientSecret,
+		Scopes:       cfg.YouTube.Scopes,
+		Endpoint:     google.Endpoint,
+	}
+	cfg.InstagramOAuthConfig = &oauth2.Config{
+		RedirectURL:  cfg.CallbackURL("instagram"),
+		ClientID:     creds.Instagram.ClientID,
+		ClientSecret: creds.Instagram.ClientSecret,
+		Scopes:       cfg.Instagram.Scopes,
+		Endpoint: oauth2.Endpoint{
+			// Business login authorizes on www.instagram.com, tokens still come from api.instagram.com
+			AuthURL:  "https://www.instagram.com/oauth/authorize",
+			TokenURL: "https://api.instagram.com/oauth/access_token",
 		},
-		TikTokOAuthConfig: &oauth2.Config{
-			RedirectURL:  "https://2b21-136-56-177-106.ngrok-free.app/callback/tiktok",
-			ClientID:     creds.TikTok.ClientKey,
-			ClientSecret: creds.TikTok.ClientSecret,
-			Scopes:       []string{"user.info.basic", "video.upload", "video.publish"},
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  "https://www.tiktok.com/v2/auth/authorize/",
-				TokenURL: "https://open.tiktokapis.com/v2/oauth/token/",
-			},
+	}
+	cfg.TikTokOAuthConfig = &oauth2.Config{
+		RedirectURL:  cfg.CallbackURL("tiktok"),
+		ClientID:     creds.TikTok.ClientKey,
+		ClientSecret: creds.TikTok.ClientSecret,
+		Scopes:       cfg.TikTok.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://www.tiktok.com/v2/auth/authorize/",
+			TokenURL: "https://open.tiktokapis.com/v2/oauth/token/",
 		},
-		RandomState:  "random",    // Consider making this truly random per request
-		TemplatesDir: "templates", // Consider making this configurable
 	}
 
 	// Update the global config variable upon successful load
@@ -92,6 +308,111 @@ func Load(filename string) (*Config, error) {
 	return globalConfig, nil // Return the newly created/updated config
 }
 
+// CallbackURL returns the OAuth redirect URL of a platform
+func (c *Config) CallbackURL(platform string) string {
+	return c.BaseURL + "/callback/" + platform
+}
+
+// loadFile merges the settings of a YAML or JSON config file into cfg.
+// Without an explicit path the default file names are tried, and it is
+// not an error for none of them to exist.
+func loadFile(cfg *Config, path string) error {
+	if path == "" {
+		for _, name := range defaultConfigFiles {
+			if _, err := os.Stat(name); err == nil {
+				path = name
+				break
+			}
+		}
+		if path == "" {
+			return nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file '%s': %w", path, err)
+	}
+
+	// YAML is converted to JSON so one set of field tags serves both formats
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse config file '%s': %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("failed to parse config file '%s': %w", path, err)
+		}
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to parse config file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// set parses value into the setting ptr points to
+func set(ptr interface{}, value string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*p = n
+	default:
+		return fmt.Errorf("unsupported setting type %T", ptr)
+	}
+	return nil
+}
+
+// validate checks the merged settings and normalizes the base URL
+func (c *Config) validate() error {
+	base, err := url.Parse(c.BaseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return fmt.Errorf("base URL %q must be an absolute http(s) URL", c.BaseURL)
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+
+	if c.Upload.MaxSizeMB < 0 {
+		return errors.New("upload max_size_mb must not be negative")
+	}
+	if c.Upload.Workers < 1 {
+		return errors.New("upload workers must be at least 1")
+	}
+	if c.Upload.QueueSize < 0 {
+		return errors.New("upload queue_size must not be negative")
+	}
+	if c.Upload.RetainHours < 0 {
+		return errors.New("upload retain_hours must not be negative")
+	}
+	switch c.YouTube.PrivacyStatus {
+	case "private", "unlisted", "public":
+	default:
+		return fmt.Errorf("youtube privacy_status %q must be private, unlisted or public", c.YouTube.PrivacyStatus)
+	}
+	if c.YouTube.ShortsMaxSeconds < 1 {
+		return errors.New("youtube shorts_max_seconds must be at least 1")
+	}
+	if err := c.Instagram.validate("instagram"); err != nil {
+		return err
+	}
+	if err := c.TikTok.validate("tiktok"); err != nil {
+		return err
+	}
+	return nil
+}
+
 // loadCredentials remains the same
 func loadCredentials(filename string) (*Credentials, error) {
 	data, err := os.ReadFile(filename)
@@ -106,17 +427,36 @@ func loadCredentials(filename string) (*Credentials, error) {
 		return nil, fmt.Errorf("failed to parse credential file '%s': %w", filename, err)
 	}
 
-	// Validate YouTube credentials
-	if creds.YouTube.ClientID == "" || creds.YouTube.ClientSecret == "" {
-		return nil, fmt.Errorf("YouTube client ID or client secret is missing in '%s'", filename)
+	// A platform is enabled by giving both of its values; only one is a mistake
+	for _, p := range creds.platforms() {
+		if (p.id == "") != (p.secret == "") {
+			return nil, fmt.Errorf("%s client ID and client secret must both be set in '%s' (or both left empty to disable %s)", p.name, filename, p.name)
+		}
 	}
 
-	// Validate Instagram credentials
-	if creds.Instagram.ClientID == "" || creds.Instagram.ClientSecret == "" {
-		return nil, fmt.Errorf("Instagram client ID or client secret is missing in '%s'", filename)
+	return &creds, nil
+}
+
+// platformCredentials are the OAuth client values of one platform
+type platformCredentials struct {
+	name       string
+	id, secret string
+}
+
+// platforms returns the client values of every supported platform
+func (c *Credentials) platforms() map[string]platformCredentials {
+	return map[string]platformCredentials{
+		"youtube":   {"YouTube", c.YouTube.ClientID, c.YouTube.ClientSecret},
+		"instagram": {"Instagram", c.Instagram.ClientID, c.Instagram.ClientSecret},
+		"tiktok":    {"TikTok", c.TikTok.ClientKey, c.TikTok.ClientSecret},
 	}
+}
 
-	return &creds, nil
+// Enabled reports whether credentials are configured for the platform.
+// Platforms without credentials are left out of the server entirely.
+func (c *Config) Enabled(platform string) bool {
+	p, ok := c.Credentials.platforms()[platform]
+	return ok && p.id != "" && p.secret != ""
 }
 
 // Get returns the current global configuration
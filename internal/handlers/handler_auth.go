@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"uploader/internal/auth"
+	"uploader/internal/storage"
+)
+
+// ShowSignInPage displays the sign-in form. Before the first account
+// exists visitors are sent to the registration page instead.
+func ShowSignInPage(w http.ResponseWriter, r *http.Request) {
+	hasUsers, err := deps.Auth.HasUsers()
+	if err != nil {
+		log.Printf("Failed to count users: %v", err)
+	}
+	if err == nil && !hasUsers {
+		http.Redirect(w, r, "/register", http.StatusSeeOther)
+		return
+	}
+
+	templates.ExecuteTemplate(w, "signin.html", map[string]interface{}{
+		"Next": safeNext(r.URL.Query().Get("next")),
+	})
+}
+
+// HandleSignIn checks the submitted credentials and starts a session
+func HandleSignIn(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+	user, err := deps.Auth.Authenticate(r.FormValue("username"), r.FormValue("password"))
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidCredentials) {
+			log.Printf("Failed to sign in: %v", err)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		templates.ExecuteTemplate(w, "signin.html", map[string]interface{}{
+			"Next":     next,
+			"Username": r.FormValue("username"),
+			"Error":    "Invalid username or password",
+		})
+		return
+	}
+
+	if err := startSession(w, r, user.ID); err != nil {
+		log.Printf("Failed to create session for %s: %v", user.Username, err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %s signed in", user.Username)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// HandleSignOut ends the current session
+func HandleSignOut(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+		if err := deps.Auth.DeleteSession(cookie.Value); err != nil {
+			log.Printf("Failed to delete session: %v", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/signin", http.StatusSeeOther)
+}
+
+// ShowRegisterPage displays the account form. Anyone may create the first
+// account; after that only signed-in users can add accounts for teammates.
+func ShowRegisterPage(w http.ResponseWriter, r *http.Request) {
+	if !canRegister(w, r) {
+		return
+	}
+	templates.ExecuteTemplate(w, "register.html", map[string]interface{}{
+		"User":      auth.UserFrom(r.Context()),
+		"MinLength": auth.MinPasswordLength,
+	})
+}
+
+// HandleRegister creates an account. The first account is signed in
+// straight away; accounts created by a signed-in user are not.
+func HandleRegister(w http.ResponseWriter, r *http.Request) {
+	if !canRegister(w, r) {
+		return
+	}
+
+	current := auth.UserFrom(r.Context())
+	data := map[string]interface{}{
+		"User":      current,
+		"MinLength": auth.MinPasswordLength,
+	}
+
+	username := strings.TrimSpace(r.FormValue("username"))
+	password := r.FormValue("password")
+	if password != r.FormValue("confirm") {
+		data["Username"] = username
+		data["Error"] = "Passwords do not match"
+		w.WriteHeader(http.StatusBadRequest)
+		templates.ExecuteTemplate(w, "register.html", data)
+		return
+	}
+
+	// Without a signed-in user this is the first account, which must still
+	// be the case when it is saved
+	register := deps.Auth.Register
+	if current == nil {
+		register = deps.Auth.RegisterFirst
+	}
+	user, err := register(username, password)
+	if errors.Is(err, storage.ErrUsersExist) {
+		http.Redirect(w, r, "/signin?next=/register", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, storage.ErrUsernameTaken) {
+			log.Printf("Failed to register %q: %v", username, err)
+		}
+		data["Username"] = username
+		data["Error"] = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+		templates.ExecuteTemplate(w, "register.html", data)
+		return
+	}
+	log.Printf("Registered user %s", user.Username)
+
+	if current != nil {
+		data["Created"] = user.Username
+		templates.ExecuteTemplate(w, "register.html", data)
+		return
+	}
+
+	if err := startSession(w, r, user.ID); err != nil {
+		log.Printf("Failed to create session for %s: %v", user.Username, err)
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/upload", http.StatusSeeOther)
+}
+
+// canRegister reports whether the request may create an account,
+// redirecting to the sign-in page when it may not
+func canRegister(w http.ResponseWriter, r *http.Request) bool {
+	if auth.UserFrom(r.Context()) != nil {
+		return true
+	}
+
+	hasUsers, err := deps.Auth.HasUsers()
+	if err != nil {
+		log.Printf("Failed to count users: %v", err)
+		http.Error(w, "Failed to load accounts", http.StatusInternalServerError)
+		return false
+	}
+	if hasUsers {
+		http.Redirect(w, r, "/signin?next=/register", http.StatusSeeOther)
+		return false
+	}
+	return true
+}
+
+// startSession creates a session for the user and sets its cookie
+func startSession(w http.ResponseWriter, r *http.Request, userID string) error {
+	token, expires, err := deps.Auth.CreateSession(userID)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// safeNext returns the local path to continue to after signing in,
+// ignoring anything that would leave the site
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/upload"
+	}
+	return next
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"uploader/internal/auth"
+	"uploader/internal/models"
+	"uploader/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// platformConnections is a platform and the accounts connected on it
+type platformConnections struct {
+	Name        string
+	DisplayName string
+	Accounts    []models.Account
+}
+
+// accountPickerView is the data for the account_picker.html template
+type accountPickerView struct {
+	Platform string
+	Accounts []models.Account
+}
+
+func newAccountPickerView(platform string, accounts []models.Account) accountPickerView {
+	return accountPickerView{Platform: platform, Accounts: accounts}
+}
+
+// connectedAccounts returns the user's connected accounts keyed by platform
+func connectedAccounts(userID string) map[string][]models.Account {
+	accounts := make(map[string][]models.Account)
+	for _, p := range services.Platforms() {
+		list, err := deps.Tokens.Accounts(userID, p.Name())
+		if err != nil {
+			log.Printf("Failed to list %s accounts: %v", p.DisplayName(), err)
+			continue
+		}
+		accounts[p.Name()] = list
+	}
+	return accounts
+}
+
+// ShowConnectionsPage lists the platform accounts the user has connected
+func ShowConnectionsPage(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFrom(r.Context())
+	accounts := connectedAccounts(user.ID)
+
+	var platforms []platformConnections
+	for _, p := range services.Platforms() {
+		platforms = append(platforms, platformConnections{
+			Name:        p.Name(),
+			DisplayName: p.DisplayName(),
+			Accounts:    accounts[p.Name()],
+		})
+	}
+
+	templates.ExecuteTemplate(w, "connections.html", map[string]interface{}{
+		"User":      user,
+		"Platforms": platforms,
+	})
+}
+
+// HandleDisconnect removes a connected platform account. The response is
+// empty so HTMX can swap the account out of the list.
+func HandleDisconnect(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFrom(r.Context())
+	platform := chi.URLParam(r, "platform")
+	accountID := chi.URLParam(r, "account")
+
+	if _, ok := services.Lookup(platform); !ok {
+		http.Error(w, "Unknown platform", http.StatusNotFound)
+		return
+	}
+	if err := deps.Tokens.Delete(user.ID, platform, accountID); err != nil {
+		log.Printf("Failed to disconnect %s account %s: %v", platform, accountID, err)
+		http.Error(w, "Failed to disconnect account", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %s disconnected %s account %s", user.Username, platform, accountID)
+	w.WriteHeader(http.StatusOK)
+}
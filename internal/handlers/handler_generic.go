@@ -1,29 +1,89 @@
 package handlers
 
 import (
-	"bytes"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
-
+	"net/url"
+	"path/filepath"
+	"slices"
+
+	"uploader/internal/auth"
+	"uploader/internal/config"
+	"uploader/internal/jobs"
+	"uploader/internal/mediaurl"
 	"uploader/internal/models"
 	"uploader/internal/services"
+	"uploader/internal/tokens"
 )
 
 var templates *template.Template
 
-func init() {
-	// Load templates
-	var err error
-	templates, err = template.ParseGlob("templates/*.html")
+// templateFuncs are helpers available to every template
+var templateFuncs = template.FuncMap{
+	"formatBytes":    formatBytes,
+	"playlistValue":  services.YouTubePlaylistValue,
+	"shortsEligible": services.ShortsEligible,
+	"platformView":   newPlatformView,
+	"accountPicker":  newAccountPickerView,
+}
+
+// Dependencies holds the long-lived services used by the handlers
+type Dependencies struct {
+	Auth        *auth.Service
+	OAuthStates *auth.StateStore
+	Jobs        *jobs.Manager
+	Tokens      *tokens.Manager
+	MediaURLs   *mediaurl.Signer
+}
+
+var deps Dependencies
+
+// Setup provides the handlers with their dependencies and loads the
+// templates; it must be called before the router starts serving requests.
+func Setup(d Dependencies) error {
+	deps = d
+
+	dir := config.Get().TemplatesDir
+	t, err := template.New("").Funcs(templateFuncs).ParseGlob(filepath.Join(dir, "*.html"))
 	if err != nil {
-		log.Fatalf("Failed to parse templates: %v", err)
+		return fmt.Errorf("failed to parse templates in '%s': %w", dir, err)
+	}
+	templates = t
+	return nil
+}
+
+// formatBytes renders a byte count in a human readable unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
 	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// enabledPlatforms reports which platforms are available, keyed by name,
+// so templates can hide the others
+func enabledPlatforms() map[string]bool {
+	enabled := make(map[string]bool)
+	for _, p := range services.Platforms() {
+		enabled[p.Name()] = true
+	}
+	return enabled
 }
 
 // ShowHomePage displays the home page
 func ShowHomePage(w http.ResponseWriter, r *http.Request) {
-	templates.ExecuteTemplate(w, "index.html", nil)
+	templates.ExecuteTemplate(w, "index.html", map[string]interface{}{
+		"Enabled": enabledPlatforms(),
+	})
 }
 
 // ShowTermsPage displays the terms of service page
@@ -38,7 +98,13 @@ func ShowPrivacyPage(w http.ResponseWriter, r *http.Request) {
 
 // ShowUploadPage displays the upload form
 func ShowUploadPage(w http.ResponseWriter, r *http.Request) {
-	templates.ExecuteTemplate(w, "upload.html", nil)
+	user := auth.UserFrom(r.Context())
+	templates.ExecuteTemplate(w, "upload.html", map[string]interface{}{
+		"User":     user,
+		"Enabled":  enabledPlatforms(),
+		"Accounts": connectedAccounts(user.ID),
+		"YouTube":  config.Get().YouTube,
+	})
 }
 
 // ShowDataRemovalPage displays the data removal request page
@@ -46,13 +112,21 @@ func ShowDataRemovalPage(w http.ResponseWriter, r *http.Request) {
 	templates.ExecuteTemplate(w, "data-removal.html", nil)
 }
 
-// HandleUpload processes the upload form submission
+// HandleUpload processes the upload form submission.
+// The video is staged and queued as a background job; the response is a
+// result fragment that polls /jobs/{id} until every platform has finished.
 func HandleUpload(w http.ResponseWriter, r *http.Request) {
-	result := &models.UploadResult{} // Initialize result struct
-
 	// Parse the multipart form (consider increasing max memory if handling very large uploads simultaneously)
 	// Using 32MB here allows form values up to 32MB in memory, file parts are streamed.
+	if limit := config.Get().Upload.MaxBytes(); limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
 	err := r.ParseMultipartForm(32 << 20) // 32MB max memory for non-file parts
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Video is too large, the limit is %s", formatBytes(tooLarge.Limit)), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to parse multipart form: %v", err)
 		http.Error(w, "Failed to parse form (file might be too large or form malformed)", http.StatusBadRequest)
@@ -66,12 +140,6 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create a map to easily check selected platforms in the template
-	selectedPlatformsMap := make(map[string]bool)
-	for _, p := range platforms {
-		selectedPlatformsMap[p] = true
-	}
-
 	// Get the uploaded video file
 	file, header, err := r.FormFile("video")
 	if err != nil {
@@ -84,90 +152,99 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 	// Log file details
 	log.Printf("Received file: %s, size: %d bytes", header.Filename, header.Size)
 
-	// Get the main caption
-	mainCaption := r.FormValue("mainCaption")
-
-	// Get the request context to pass down to service functions
-	ctx := r.Context()
-
-	// Handle uploads to selected platforms
-	for _, platform := range platforms {
-		// Reset file position before each platform upload attempt
-		// This is crucial because each service function will read the file.
-		if _, err := file.Seek(0, 0); err != nil {
-			log.Printf("CRITICAL: Failed to reset file position before uploading to %s: %v", platform, err)
-			// Record an error for this platform and skip it
-			switch platform {
-			case "youtube":
-				result.YouTube.Success = false
-				result.YouTube.Error = "Internal server error: failed to prepare file for upload"
-			case "instagram":
-				result.Instagram.Success = false
-				result.Instagram.Error = "Internal server error: failed to prepare file for upload"
-			case "tiktok":
-				result.TikTok.Success = false
-				result.TikTok.Error = "Internal server error: failed to prepare file for upload"
-			}
-			continue // Skip to the next platform
+	// Other files, such as a thumbnail, are staged alongside the video
+	var attachments []jobs.Attachment
+	for field, headers := range r.MultipartForm.File {
+		if field == "video" || len(headers) == 0 {
+			continue
 		}
-
-		// Process upload for the current platform
-		switch platform {
-		case "youtube":
-			title := r.FormValue("youtubeTitle")
-			if title == "" {
-				log.Printf("Skipping YouTube upload: Title is required.")
-				result.YouTube.Success = false
-				result.YouTube.Error = "YouTube title is required"
-				continue // Skip YouTube upload if title missing
-			}
-			description := r.FormValue("youtubeDescription")
-			// Pass context if/when UploadToYoutube supports it
-			err := services.UploadToYoutube(file, header, title, description, mainCaption, result)
-			if err != nil {
-				log.Printf("YouTube upload failed: %v", err)
-				// Error details are already set within UploadToYoutube (or should be)
-			}
-		case "instagram":
-			instagramCaption := r.FormValue("instagramCaption")
-			// Pass context if/when UploadToInstagram supports it
-			err := services.UploadToInstagram(file, header, instagramCaption, mainCaption, result)
-			if err != nil {
-				log.Printf("Instagram upload failed: %v", err)
-				// Error details are already set within UploadToInstagram (or should be)
-			}
-		case "tiktok":
-			tiktokCaption := r.FormValue("tiktokCaption")
-			// Pass the request context (ctx) as the first argument
-			err := services.UploadToTikTok(ctx, file, header, tiktokCaption, mainCaption, result)
-			if err != nil {
-				log.Printf("TikTok upload failed: %v", err)
-				// Error details are already set within UploadToTikTok (or should be)
-			}
+		part, err := headers[0].Open()
+		if err != nil {
+			log.Printf("Failed to open %s from form: %v", field, err)
+			http.Error(w, fmt.Sprintf("Failed to read %s", headers[0].Filename), http.StatusBadRequest)
+			return
 		}
+		defer part.Close()
+		attachments = append(attachments, jobs.Attachment{
+			Field:    field,
+			Filename: headers[0].Filename,
+			Size:     headers[0].Size,
+			File:     part,
+		})
 	}
 
-	// --- Render the result ---
-
-	// Create a buffer to store the result HTML content
-	var buf bytes.Buffer
-
-	// Prepare data structure for the template
-	templateData := map[string]interface{}{
-		"Result":            result,               // Pass the result struct
-		"SelectedPlatforms": selectedPlatformsMap, // Pass the map of selected platforms
+	// Work out which connected accounts the video goes to
+	userID := auth.UserFrom(r.Context()).ID
+	targets, err := uploadTargets(userID, platforms, r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Execute the result content template
-	if err := templates.ExecuteTemplate(&buf, "result_content.html", templateData); err != nil {
-		log.Printf("Failed to execute result template: %v", err)
-		// Send a generic error response, but log the detailed one
-		http.Error(w, "Failed to display upload results", http.StatusInternalServerError)
+	job, err := deps.Jobs.Submit(jobs.Submission{
+		UserID:      userID,
+		Filename:    header.Filename,
+		Size:        header.Size,
+		File:        file,
+		Targets:     targets,
+		Attachments: attachments,
+		Metadata: services.Metadata{
+			UserID:      userID,
+			MainCaption: r.FormValue("mainCaption"),
+			Values:      r.MultipartForm.Value,
+		},
+	})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to queue upload job: %v", err)
+		http.Error(w, "Failed to queue upload", http.StatusInternalServerError)
 		return
 	}
 
-	// Write the generated HTML fragment to the response
-	// This is intended for use with HTMX, replacing the #result div content
-	w.Header().Set("Content-Type", "text/html; charset=utf-8") // Set appropriate content type
-	w.Write(buf.Bytes())
+	// Point the browser at the job page so a reload reconnects to it
+	w.Header().Set("HX-Push-Url", "/jobs/"+job.ID)
+	renderJobFragment(w, &job)
+}
+
+// uploadTargets resolves the accounts chosen in the "<platform>Account"
+// form fields. A platform with a single connected account uses it without
+// asking; one with no accounts yields a target that fails with a hint.
+// Repeated platforms and accounts are only uploaded to once.
+func uploadTargets(userID string, platforms []string, form url.Values) ([]jobs.Target, error) {
+	var targets []jobs.Target
+	for i, platform := range platforms {
+		if slices.Contains(platforms[:i], platform) {
+			continue
+		}
+		accounts, err := deps.Tokens.Accounts(userID, platform)
+		if err != nil {
+			log.Printf("Failed to list %s accounts: %v", platform, err)
+		}
+
+		chosen := form[platform+"Account"]
+		switch {
+		case len(accounts) == 0:
+			targets = append(targets, jobs.Target{Platform: platform})
+			continue
+		case len(chosen) == 0 && len(accounts) == 1:
+			chosen = []string{accounts[0].ID}
+		case len(chosen) == 0:
+			return nil, fmt.Errorf("choose at least one %s account", platform)
+		}
+
+		for j, id := range chosen {
+			if slices.Contains(chosen[:j], id) {
+				continue
+			}
+			i := slices.IndexFunc(accounts, func(a models.Account) bool { return a.ID == id })
+			if i < 0 {
+				return nil, fmt.Errorf("unknown %s account %q", platform, id)
+			}
+			targets = append(targets, jobs.Target{Platform: platform, AccountID: id, AccountName: accounts[i].Name})
+		}
+	}
+	return targets, nil
 }
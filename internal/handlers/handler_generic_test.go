@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+
+	"uploader/internal/config"
+	"uploader/internal/jobs"
+	"uploader/internal/models"
+	"uploader/internal/tokens"
+)
+
+// withTokens gives the handlers a token manager on a MemoryStore holding
+// the given accounts of user "alice"
+func withTokens(t *testing.T, accounts ...models.Account) {
+	t.Helper()
+	store := tokens.NewMemoryStore()
+	for _, a := range accounts {
+		store.Put("alice", a.Platform, a.ID, &models.Token{AccessToken: "token", UserID: a.ID, Name: a.Name})
+	}
+	old := deps
+	deps = Dependencies{Tokens: tokens.NewManager(&config.Config{}, store)}
+	t.Cleanup(func() { deps = old })
+}
+
+func TestUploadTargetsSkipsRepeats(t *testing.T) {
+	withTokens(t,
+		models.Account{Platform: "youtube", ID: "chan1", Name: "One"},
+		models.Account{Platform: "youtube", ID: "chan2", Name: "Two"},
+		models.Account{Platform: "tiktok", ID: "open1", Name: "creator"},
+	)
+
+	form := url.Values{
+		"platforms":      {"youtube", "tiktok", "youtube", "tiktok"},
+		"youtubeAccount": {"chan1", "chan2", "chan1"},
+	}
+	targets, err := uploadTargets("alice", form["platforms"], form)
+	if err != nil {
+		t.Fatalf("uploadTargets: %v", err)
+	}
+
+	want := []jobs.Target{
+		{Platform: "youtube", AccountID: "chan1", AccountName: "One"},
+		{Platform: "youtube", AccountID: "chan2", AccountName: "Two"},
+		{Platform: "tiktok", AccountID: "open1", AccountName: "creator"},
+	}
+	if len(targets) != len(want) {
+		t.Fatalf("got %d targets %+v, want %+v", len(targets), targets, want)
+	}
+	for i := range want {
+		if targets[i] != want[i] {
+			t.Errorf("target %d = %+v, want %+v", i, targets[i], want[i])
+		}
+	}
+}
+
+func TestUploadTargetsNoAccountRepeated(t *testing.T) {
+	withTokens(t)
+
+	targets, err := uploadTargets("alice", []string{"instagram", "instagram"}, url.Values{})
+	if err != nil {
+		t.Fatalf("uploadTargets: %v", err)
+	}
+	if len(targets) != 1 || targets[0] != (jobs.Target{Platform: "instagram"}) {
+		t.Errorf("got targets %+v, want one target without an account", targets)
+	}
+}
+
+func TestUploadTargetsErrors(t *testing.T) {
+	withTokens(t,
+		models.Account{Platform: "youtube", ID: "chan1", Name: "One"},
+		models.Account{Platform: "youtube", ID: "chan2", Name: "Two"},
+	)
+
+	for name, form := range map[string]url.Values{
+		"no account chosen": {},
+		"unknown account":   {"youtubeAccount": {"chan1", "chan3"}},
+	} {
+		if targets, err := uploadTargets("alice", []string{"youtube"}, form); err == nil {
+			t.Errorf("%s: got targets %+v, want an error", name, targets)
+		}
+	}
+}
@@ -1,51 +1,64 @@
 package handlers
 
 import (
-	"context"
-	"encoding/json"
 	"log"
 	"net/http"
-	"os"
 
+	"uploader/internal/auth"
 	"uploader/internal/config"
+	"uploader/internal/tokens"
 )
 
-// HandleInstagramLogin initiates the Instagram OAuth flow
+// HandleInstagramLogin initiates the Instagram OAuth flow.
+// Instagram does not support PKCE, so only the state is checked.
 func HandleInstagramLogin(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
-	url := cfg.InstagramOAuthConfig.AuthCodeURL(cfg.RandomState)
+	state, _, err := beginOAuth(w, r, "instagram")
+	if err != nil {
+		log.Printf("Failed to start Instagram login: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	url := cfg.InstagramOAuthConfig.AuthCodeURL(state)
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 // HandleInstagramCallback processes the Instagram OAuth callback
 func HandleInstagramCallback(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
-	if r.URL.Query().Get("state") != cfg.RandomState {
+	if _, err := finishOAuth(w, r, "instagram"); err != nil {
 		http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 		return
 	}
 
-	token, err := cfg.InstagramOAuthConfig.Exchange(context.Background(), r.URL.Query().Get("code"))
+	token, err := cfg.InstagramOAuthConfig.Exchange(r.Context(), r.URL.Query().Get("code"))
 	if err != nil {
 		log.Printf("Instagram code exchange failed: %v", err) // Added logging
 		http.Error(w, "Code exchange failed", http.StatusInternalServerError)
 		return
 	}
 
-	// Save the Instagram token
-	tokenFile, err := json.Marshal(token)
+	// Swap the short-lived token for a long-lived one that can be refreshed
+	longLived, err := deps.Tokens.ExchangeInstagram(r.Context(), tokens.FromOAuth2(token))
 	if err != nil {
-		log.Printf("Unable to marshal Instagram token: %v", err)
-		http.Error(w, "Failed to process authentication token", http.StatusInternalServerError)
+		log.Printf("Instagram long-lived token exchange failed: %v", err)
+		http.Error(w, "Code exchange failed", http.StatusInternalServerError)
 		return
 	}
 
-	err = os.WriteFile("instagram_token.json", tokenFile, 0600)
-	if err != nil {
-		log.Printf("Unable to write Instagram token to file: %v", err)
+	if err := deps.Tokens.Identify(r.Context(), "instagram", longLived); err != nil {
+		log.Printf("Unable to identify Instagram account: %v", err)
+		http.Error(w, "Failed to look up your Instagram account", http.StatusInternalServerError)
+		return
+	}
+
+	// Save the Instagram token
+	if err := deps.Tokens.Save(auth.UserFrom(r.Context()).ID, "instagram", longLived); err != nil {
+		log.Printf("Unable to save Instagram token: %v", err)
 		http.Error(w, "Failed to save authentication token", http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, "/upload", http.StatusSeeOther)
+	http.Redirect(w, r, "/connections", http.StatusSeeOther)
 }
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"uploader/internal/auth"
+	"uploader/internal/jobs"
+	"uploader/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleJobStatus reports the per-platform status of an upload job.
+// HTMX requests get the result fragment, JSON clients get the job itself
+// and anyone else gets the full result page, so a closed tab can reconnect.
+func HandleJobStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := userJob(r, chi.URLParam(r, "id"))
+	if !ok {
+		http.Error(w, "Upload job not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Header.Get("HX-Request") == "true":
+		renderJobFragment(w, &job)
+	case strings.Contains(r.Header.Get("Accept"), "application/json"):
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(job); err != nil {
+			log.Printf("Failed to encode job %s: %v", job.ID, err)
+		}
+	default:
+		if err := templates.ExecuteTemplate(w, "result.html", &job); err != nil {
+			log.Printf("Failed to execute result page template: %v", err)
+			http.Error(w, "Failed to display upload results", http.StatusInternalServerError)
+		}
+	}
+}
+
+// platformView is the data for the platform_status.html template
+type platformView struct {
+	JobID    string
+	CanRetry bool // Whether a failed upload can be retried from the kept video
+	models.PlatformResult
+}
+
+func newPlatformView(job *models.Job, res models.PlatformResult) platformView {
+	return platformView{JobID: job.ID, CanRetry: job.CanRetry(res), PlatformResult: res}
+}
+
+// HandleJobEvents streams server-sent events for a running job. Each event
+// is named after a platform upload's key and carries its rendered status card, for use
+// with the HTMX SSE extension. A final "done" event is sent when the job ends.
+func HandleJobEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before taking the snapshot so no change is missed
+	id := chi.URLParam(r, "id")
+	events, unsubscribe := deps.Jobs.Subscribe(id)
+	defer unsubscribe()
+
+	job, ok := userJob(r, id)
+	if !ok {
+		http.Error(w, "Upload job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	for _, res := range job.Result.Platforms {
+		writePlatformEvent(w, &job, res)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				writeEvent(w, "done", "")
+				flusher.Flush()
+				return
+			}
+			writePlatformEvent(w, &job, ev.Result)
+			flusher.Flush()
+		}
+	}
+}
+
+// writePlatformEvent sends the rendered status card of one platform
+func writePlatformEvent(w http.ResponseWriter, job *models.Job, res models.PlatformResult) {
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "platform_status.html", newPlatformView(job, res)); err != nil {
+		log.Printf("Failed to execute platform status template: %v", err)
+		return
+	}
+	writeEvent(w, res.Key(), buf.String())
+}
+
+// writeEvent writes a single server-sent event; multi-line data is split
+// into one data field per line as the SSE format requires
+func writeEvent(w io.Writer, name, data string) {
+	fmt.Fprintf(w, "event: %s\n", name)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// HandleCancelJob cancels a running upload job, or only the upload named
+// by the "target" query parameter when it is given
+func HandleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := userJob(r, id); !ok {
+		http.Error(w, "Upload job not found", http.StatusNotFound)
+		return
+	}
+
+	if !deps.Jobs.Cancel(id, r.URL.Query().Get("target")) {
+		http.Error(w, "No running upload to cancel", http.StatusConflict)
+		return
+	}
+
+	job, _ := deps.Jobs.Get(id)
+	renderJobFragment(w, &job)
+}
+
+// HandleRetryJob runs the failed upload named by the "target" query
+// parameter again from the kept copy of the video
+func HandleRetryJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := userJob(r, id); !ok {
+		http.Error(w, "Upload job not found", http.StatusNotFound)
+		return
+	}
+
+	err := deps.Jobs.Retry(id, r.URL.Query().Get("target"))
+	switch {
+	case errors.Is(err, jobs.ErrQueueFull):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, jobs.ErrCannotRetry), errors.Is(err, jobs.ErrJobActive):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to retry upload of job %s: %v", id, err)
+		http.Error(w, "Failed to retry upload", http.StatusInternalServerError)
+		return
+	}
+
+	job, _ := deps.Jobs.Get(id)
+	renderJobFragment(w, &job)
+}
+
+// userJob returns the job with the given ID if it belongs to the signed-in
+// user. Other users' jobs are reported as not found.
+func userJob(r *http.Request, id string) (models.Job, bool) {
+	job, ok := deps.Jobs.Get(id)
+	if !ok {
+		return models.Job{}, false
+	}
+	user := auth.UserFrom(r.Context())
+	if user == nil || job.UserID != user.ID {
+		return models.Job{}, false
+	}
+	return job, true
+}
+
+// renderJobFragment writes the result fragment for a job.
+// This is intended for use with HTMX, replacing the #result div content.
+func renderJobFragment(w http.ResponseWriter, job *models.Job) {
+	// Render into a buffer so a template error doesn't leave a half-written response
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "result_content.html", job); err != nil {
+		log.Printf("Failed to execute result template: %v", err)
+		// Send a generic error response, but log the detailed one
+		http.Error(w, "Failed to display upload results", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8") // Set appropriate content type
+	w.Write(buf.Bytes())
+}
+
+// historyLimit is the number of past uploads shown on the history page
+const historyLimit = 100
+
+// ShowHistoryPage lists past uploads and their per-platform outcomes
+func ShowHistoryPage(w http.ResponseWriter, r *http.Request) {
+	user := auth.UserFrom(r.Context())
+	history, err := deps.Jobs.History(user.ID, historyLimit)
+	if err != nil {
+		log.Printf("Failed to load upload history: %v", err)
+		http.Error(w, "Failed to load upload history", http.StatusInternalServerError)
+		return
+	}
+
+	templates.ExecuteTemplate(w, "history.html", map[string]interface{}{
+		"User": user,
+		"Jobs": history,
+	})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"uploader/internal/config"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleMedia serves a staged video to platforms that download it
+// themselves, such as Instagram. The route is public, so every request
+// needs an unexpired signature from mediaurl.Signer.
+func HandleMedia(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	q := r.URL.Query()
+	if err := deps.MediaURLs.Verify(name, q.Get("expires"), q.Get("sig")); err != nil {
+		log.Printf("Rejected media request for %q: %v", name, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	file, err := os.Open(filepath.Join(config.Get().StagingDir, name))
+	if err != nil {
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		log.Printf("Failed to stat staged media %s: %v", name, err)
+		http.Error(w, "Media not found", http.StatusNotFound)
+		return
+	}
+
+	// ServeContent handles Range requests, which video fetchers rely on.
+	// Without a known extension it sniffs the type from the file contents.
+	if contentType, ok := videoTypes[strings.ToLower(filepath.Ext(name))]; ok {
+		w.Header().Set("Content-Type", contentType)
+	}
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// videoTypes are the content types of video extensions the standard
+// library's MIME table does not know
+var videoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+}
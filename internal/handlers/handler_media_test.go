@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"uploader/internal/config"
+	"uploader/internal/mediaurl"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestHandleMediaContentType(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := config.Load([]string{
+		"-base-url", "http://localhost:3000",
+		"-credentials", "../../example_creds.json",
+		"-staging-dir", dir,
+	}); err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	signer := mediaurl.NewSigner("http://localhost:3000", []byte("secret"))
+	old := deps
+	deps = Dependencies{MediaURLs: signer}
+	t.Cleanup(func() { deps = old })
+
+	router := chi.NewRouter()
+	router.Get(mediaurl.Prefix+"{name}", HandleMedia)
+
+	// MP4 and QuickTime files both start with an ftyp box
+	mp4 := append([]byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isommp41"), make([]byte, 64)...)
+	quicktime := append([]byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00qt  "), make([]byte, 64)...)
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"job.mp4", mp4, "video/mp4"},
+		{"job.mov", quicktime, "video/quicktime"},
+		{"job.MOV", quicktime, "video/quicktime"},
+		{"job.webm", []byte("\x1a\x45\xdf\xa3webm"), "video/webm"},
+		// Without an extension the type is sniffed from the contents
+		{"job", mp4, "video/mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filepath.Join(dir, tt.name), tt.data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", strings.TrimPrefix(signer.URL(tt.name), "http://localhost:3000"), nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.want {
+				t.Errorf("Content-Type = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,20 +2,30 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"os"
+	"slices"
 	"strings"
 
+	"uploader/internal/auth"
 	"uploader/internal/config"
 	"uploader/internal/models"
+	"uploader/internal/services"
+	"uploader/internal/tokens"
 )
 
 // HandleTikTokLogin initiates the TikTok OAuth flow
 func HandleTikTokLogin(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
+	state, verifier, err := beginOAuth(w, r, "tiktok")
+	if err != nil {
+		log.Printf("Failed to start TikTok login: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
 
 	// Build the auth URL manually to ensure all params are included
 	baseURL := "https://www.tiktok.com/v2/auth/authorize/"
@@ -24,7 +34,10 @@ func HandleTikTokLogin(w http.ResponseWriter, r *http.Request) {
 	params.Add("response_type", "code")
 	params.Add("scope", strings.Join(cfg.TikTokOAuthConfig.Scopes, ","))
 	params.Add("redirect_uri", cfg.TikTokOAuthConfig.RedirectURL)
-	params.Add("state", cfg.RandomState)
+	params.Add("state", state)
+	params.Add("code_challenge", tiktokCodeChallenge(verifier))
+	params.Add("code_challenge_method", "S256")
+	params.Add("disable_auto_auth", "1") // Always ask, so another account can be connected
 
 	authURL := baseURL + "?" + params.Encode()
 
@@ -34,7 +47,8 @@ func HandleTikTokLogin(w http.ResponseWriter, r *http.Request) {
 // HandleTikTokCallback processes the TikTok OAuth callback
 func HandleTikTokCallback(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
-	if r.URL.Query().Get("state") != cfg.RandomState {
+	verifier, err := finishOAuth(w, r, "tiktok")
+	if err != nil {
 		http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 		return
 	}
@@ -53,6 +67,7 @@ func HandleTikTokCallback(w http.ResponseWriter, r *http.Request) {
 	data.Set("code", code)
 	data.Set("grant_type", "authorization_code")
 	data.Set("redirect_uri", cfg.TikTokOAuthConfig.RedirectURL)
+	data.Set("code_verifier", verifier)
 
 	// Use request's context for the token exchange request
 	req, err := http.NewRequestWithContext(r.Context(), "POST", tokenURL, strings.NewReader(data.Encode()))
@@ -86,20 +101,109 @@ func HandleTikTokCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The open_id identifies the account; the display name is only nice to have
+	tok := tokens.TikTokToken(tokenResponse)
+	if err := deps.Tokens.Identify(r.Context(), "tiktok", tok); err != nil {
+		log.Printf("Unable to look up TikTok display name: %v", err)
+	}
+
 	// Save the token
-	tokenFile, err := json.Marshal(tokenResponse)
-	if err != nil {
-		log.Printf("Unable to marshal TikTok token: %v", err)
-		http.Error(w, "Failed to process authentication token", http.StatusInternalServerError)
+	if err := deps.Tokens.Save(auth.UserFrom(r.Context()).ID, "tiktok", tok); err != nil {
+		log.Printf("Unable to save TikTok token: %v", err)
+		http.Error(w, "Failed to save authentication token", http.StatusInternalServerError)
 		return
 	}
 
-	err = os.WriteFile("tiktok_token.json", tokenFile, 0600)
-	if err != nil {
-		log.Printf("Unable to write TikTok token to file: %v", err)
-		http.Error(w, "Failed to save authentication token", http.StatusInternalServerError)
+	http.Redirect(w, r, "/connections", http.StatusSeeOther)
+}
+
+// tiktokPrivacyOption is one privacy level offered on the upload form
+type tiktokPrivacyOption struct {
+	Value    string
+	Label    string
+	Selected bool
+}
+
+// tiktokOptionsView is the data for the tiktok_options.html template. With
+// several accounts chosen it offers what all of them allow.
+type tiktokOptionsView struct {
+	Creators        []string
+	Privacy         []tiktokPrivacyOption
+	CommentDisabled bool
+	DuetDisabled    bool
+	StitchDisabled  bool
+	MaxDuration     int // Seconds; the shortest limit of the chosen accounts
+	Errors          []string
+}
+
+// HandleTikTokOptions renders the TikTok post options of the upload form
+// for the accounts chosen in the "tiktokAccount" query parameters, as
+// reported by TikTok's creator_info query
+func HandleTikTokOptions(w http.ResponseWriter, r *http.Request) {
+	platform, ok := services.Lookup("tiktok")
+	tiktok, isTikTok := platform.(*services.TikTok)
+	if !ok || !isTikTok {
+		http.Error(w, "TikTok is not enabled", http.StatusNotFound)
 		return
 	}
 
-	http.Redirect(w, r, "/upload", http.StatusSeeOther)
+	userID := auth.UserFrom(r.Context()).ID
+	accounts, err := deps.Tokens.Accounts(userID, "tiktok")
+	if err != nil {
+		log.Printf("Failed to list TikTok accounts: %v", err)
+	}
+	chosen := r.URL.Query()["tiktokAccount"]
+	if len(chosen) == 0 && len(accounts) == 1 {
+		chosen = []string{accounts[0].ID}
+	}
+
+	var view tiktokOptionsView
+	var allowed []string
+	for _, id := range chosen {
+		i := slices.IndexFunc(accounts, func(a models.Account) bool { return a.ID == id })
+		if i < 0 {
+			continue
+		}
+		name := accounts[i].Name
+
+		info, err := tiktok.CreatorInfo(r.Context(), userID, id)
+		if err != nil {
+			log.Printf("Failed to query TikTok creator info of %s: %v", name, err)
+			msg := fmt.Sprintf("Could not load the options of %s: %v", name, err)
+			if hint := services.Classify(err).Hint(); hint != "" {
+				msg += ". " + hint
+			}
+			view.Errors = append(view.Errors, msg)
+			continue
+		}
+
+		if view.Creators == nil {
+			allowed = info.PrivacyLevelOptions
+		} else {
+			allowed = slices.DeleteFunc(allowed, func(level string) bool {
+				return !slices.Contains(info.PrivacyLevelOptions, level)
+			})
+		}
+		view.Creators = append(view.Creators, name)
+		view.CommentDisabled = view.CommentDisabled || info.CommentDisabled
+		view.DuetDisabled = view.DuetDisabled || info.DuetDisabled
+		view.StitchDisabled = view.StitchDisabled || info.StitchDisabled
+		if view.MaxDuration == 0 || info.MaxVideoDuration < view.MaxDuration {
+			view.MaxDuration = info.MaxVideoDuration
+		}
+	}
+
+	for _, level := range allowed {
+		label, ok := services.TikTokPrivacyLabels[level]
+		if !ok {
+			continue // Not one we know how to post with
+		}
+		view.Privacy = append(view.Privacy, tiktokPrivacyOption{
+			Value:    level,
+			Label:    label,
+			Selected: level == config.Get().TikTok.PrivacyLevel,
+		})
+	}
+
+	templates.ExecuteTemplate(w, "tiktok_options.html", view)
 }
@@ -1,50 +1,156 @@
 package handlers
 
 import (
-	"context"
-	"encoding/json"
 	"log"
 	"net/http"
-	"os"
+	"slices"
 
+	"uploader/internal/auth"
 	"uploader/internal/config"
+	"uploader/internal/models"
+	"uploader/internal/services"
+	"uploader/internal/tokens"
+
+	"golang.org/x/oauth2"
 )
 
 // HandleYoutubeLogin initiates the YouTube OAuth flow
 func HandleYoutubeLogin(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
-	url := cfg.YouTubeOAuthConfig.AuthCodeURL(cfg.RandomState)
+	state, verifier, err := beginOAuth(w, r, "youtube")
+	if err != nil {
+		log.Printf("Failed to start YouTube login: %v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	// Offline access with forced consent makes Google return a refresh token;
+	// the account chooser lets another channel be connected
+	url := cfg.YouTubeOAuthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("prompt", "select_account consent"),
+		oauth2.S256ChallengeOption(verifier))
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
 // HandleYoutubeCallback processes the YouTube OAuth callback
 func HandleYoutubeCallback(w http.ResponseWriter, r *http.Request) {
 	cfg := config.Get()
-	if r.URL.Query().Get("state") != cfg.RandomState {
+	verifier, err := finishOAuth(w, r, "youtube")
+	if err != nil {
 		http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 		return
 	}
 
-	token, err := cfg.YouTubeOAuthConfig.Exchange(context.Background(), r.URL.Query().Get("code"))
+	token, err := cfg.YouTubeOAuthConfig.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(verifier))
 	if err != nil {
 		log.Printf("YouTube code exchange failed: %v", err) // Added logging
 		http.Error(w, "Code exchange failed", http.StatusInternalServerError)
 		return
 	}
 
-	tokenFile, err := json.Marshal(token)
-	if err != nil {
-		log.Printf("Unable to marshal YouTube token: %v", err)
-		http.Error(w, "Failed to process authentication token", http.StatusInternalServerError)
+	tok := tokens.FromOAuth2(token)
+	if err := deps.Tokens.Identify(r.Context(), "youtube", tok); err != nil {
+		log.Printf("Unable to identify YouTube channel: %v", err)
+		http.Error(w, "Failed to look up your YouTube channel", http.StatusInternalServerError)
 		return
 	}
 
-	err = os.WriteFile("youtube_token.json", tokenFile, 0600)
-	if err != nil {
-		log.Printf("Unable to write YouTube token to file: %v", err)
+	if err := deps.Tokens.Save(auth.UserFrom(r.Context()).ID, "youtube", tok); err != nil {
+		log.Printf("Unable to save YouTube token: %v", err)
 		http.Error(w, "Failed to save authentication token", http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, "/upload", http.StatusSeeOther)
+	http.Redirect(w, r, "/connections", http.StatusSeeOther)
+}
+
+// youtubeCategoriesView is the data for the youtube_categories.html template
+type youtubeCategoriesView struct {
+	Categories []models.YouTubeCategory
+	Selected   string
+	Error      string
+}
+
+// HandleYouTubeCategories renders the category choice of the upload form,
+// listing the categories with the token of the first account chosen in
+// the "youtubeAccount" query parameters
+func HandleYouTubeCategories(w http.ResponseWriter, r *http.Request) {
+	platform, ok := services.Lookup("youtube")
+	youtube, isYouTube := platform.(*services.YouTube)
+	if !ok || !isYouTube {
+		http.Error(w, "YouTube is not enabled", http.StatusNotFound)
+		return
+	}
+
+	userID := auth.UserFrom(r.Context()).ID
+	accounts, err := deps.Tokens.Accounts(userID, "youtube")
+	if err != nil {
+		log.Printf("Failed to list YouTube accounts: %v", err)
+	}
+	accountID := r.URL.Query().Get("youtubeAccount")
+	if accountID == "" && len(accounts) > 0 {
+		accountID = accounts[0].ID
+	}
+
+	view := youtubeCategoriesView{Selected: config.Get().YouTube.CategoryID}
+	if accountID != "" {
+		view.Categories, err = youtube.Categories(r.Context(), userID, accountID)
+		if err != nil {
+			log.Printf("Failed to list YouTube categories: %v", err)
+			view.Error = "Could not load the categories, the default category will be used"
+		}
+	}
+	templates.ExecuteTemplate(w, "youtube_categories.html", view)
+}
+
+// youtubePlaylistGroup is the playlists of one chosen channel
+type youtubePlaylistGroup struct {
+	AccountID   string
+	AccountName string
+	Playlists   []models.YouTubePlaylist
+	Error       string
+}
+
+// youtubePlaylistsView is the data for the youtube_playlists.html template
+type youtubePlaylistsView struct {
+	Groups []youtubePlaylistGroup
+}
+
+// HandleYouTubePlaylists renders the playlist choice of the upload form for
+// the channels chosen in the "youtubeAccount" query parameters
+func HandleYouTubePlaylists(w http.ResponseWriter, r *http.Request) {
+	platform, ok := services.Lookup("youtube")
+	youtube, isYouTube := platform.(*services.YouTube)
+	if !ok || !isYouTube {
+		http.Error(w, "YouTube is not enabled", http.StatusNotFound)
+		return
+	}
+
+	userID := auth.UserFrom(r.Context()).ID
+	accounts, err := deps.Tokens.Accounts(userID, "youtube")
+	if err != nil {
+		log.Printf("Failed to list YouTube accounts: %v", err)
+	}
+	chosen := r.URL.Query()["youtubeAccount"]
+	if len(chosen) == 0 && len(accounts) == 1 {
+		chosen = []string{accounts[0].ID}
+	}
+
+	var view youtubePlaylistsView
+	for _, account := range accounts {
+		if !slices.Contains(chosen, account.ID) {
+			continue
+		}
+		group := youtubePlaylistGroup{AccountID: account.ID, AccountName: account.Name}
+		group.Playlists, err = youtube.Playlists(r.Context(), userID, account.ID)
+		if err != nil {
+			log.Printf("Failed to list YouTube playlists of %s: %v", account.Name, err)
+			group.Error = "Could not load the playlists"
+			if kind := services.Classify(err); kind == models.ErrorAuth {
+				group.Error += ". " + kind.Hint()
+			}
+		}
+		view.Groups = append(view.Groups, group)
+	}
+	templates.ExecuteTemplate(w, "youtube_playlists.html", view)
 }
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"uploader/internal/auth"
+)
+
+// oauthCookie returns the name of the cookie holding a platform's login state
+func oauthCookie(platform string) string {
+	return "oauth_state_" + platform
+}
+
+// beginOAuth starts a platform login for the signed-in user. The state is
+// also set in a short-lived cookie so the callback must come back to the
+// same browser. It returns the state and the PKCE code verifier.
+func beginOAuth(w http.ResponseWriter, r *http.Request, platform string) (string, string, error) {
+	state, verifier, err := deps.OAuthStates.Begin(auth.UserFrom(r.Context()).ID, platform)
+	if err != nil {
+		return "", "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie(platform),
+		Value:    state,
+		Path:     "/callback/" + platform,
+		MaxAge:   int(auth.OAuthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return state, verifier, nil
+}
+
+// finishOAuth verifies and consumes the state of a platform callback and
+// returns the login's PKCE code verifier
+func finishOAuth(w http.ResponseWriter, r *http.Request, platform string) (string, error) {
+	var cookieState string
+	if cookie, err := r.Cookie(oauthCookie(platform)); err == nil {
+		cookieState = cookie.Value
+	}
+
+	// The state is single use, so the cookie is no longer needed either way
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie(platform),
+		Value:    "",
+		Path:     "/callback/" + platform,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return deps.OAuthStates.Consume(r.URL.Query().Get("state"), cookieState, auth.UserFrom(r.Context()).ID, platform)
+}
+
+// tiktokCodeChallenge derives TikTok's PKCE code challenge, which is the
+// hex encoded SHA-256 of the verifier rather than the usual base64url
+func tiktokCodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return hex.EncodeToString(sum[:])
+}
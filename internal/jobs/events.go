@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"time"
+
+	"uploader/internal/models"
+)
+
+// progressInterval limits how often progress reports are passed on to subscribers
+const progressInterval = 250 * time.Millisecond
+
+// Event is the latest state of a single platform upload within a job
+type Event struct {
+	Result models.PlatformResult
+}
+
+// Subscribe returns a channel that receives an event whenever a platform
+// upload of the job changes. The channel is closed once the job finishes,
+// or straight away if the job is not running. Call the returned function
+// to unsubscribe.
+func (m *Manager) Subscribe(jobID string) (<-chan Event, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ch := make(chan Event, 32)
+	if _, ok := m.jobs[jobID]; !ok {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if m.subs[jobID] == nil {
+		m.subs[jobID] = make(map[chan Event]struct{})
+	}
+	m.subs[jobID][ch] = struct{}{}
+
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if _, ok := m.subs[jobID][ch]; ok {
+			delete(m.subs[jobID], ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends the state of every platform of the job to its subscribers.
+// Slow subscribers miss events rather than holding up the upload. The
+// caller must hold m.mu.
+func (m *Manager) publish(job *models.Job) {
+	for ch := range m.subs[job.ID] {
+		for _, res := range job.Result.Platforms {
+			select {
+			case ch <- Event{Result: res}:
+			default:
+			}
+		}
+	}
+}
+
+// closeSubscribers ends every subscription to the job. The caller must hold m.mu.
+func (m *Manager) closeSubscribers(jobID string) {
+	for ch := range m.subs[jobID] {
+		close(ch)
+	}
+	delete(m.subs, jobID)
+}
+
+// progressReporter returns a function that records progress reports for
+// the platform at index i of the job and passes them on to subscribers
+func (m *Manager) progressReporter(jobID string, i int) func(models.Progress) {
+	var last time.Time
+	var lastPhase string
+
+	return func(p models.Progress) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// Always pass on phase changes, but throttle byte counts
+		if p.Phase == lastPhase && time.Since(last) < progressInterval {
+			return
+		}
+		last, lastPhase = time.Now(), p.Phase
+
+		job, ok := m.jobs[jobID]
+		if !ok {
+			return
+		}
+		job.Result.Platforms[i].Progress = &p
+		m.publish(job)
+	}
+}
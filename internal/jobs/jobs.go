@@ -0,0 +1,578 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"uploader/internal/models"
+	"uploader/internal/probe"
+	"uploader/internal/services"
+	"uploader/internal/storage"
+)
+
+// ErrQueueFull is returned by Submit when no more jobs can be accepted
+var ErrQueueFull = errors.New("upload queue is full, please try again later")
+
+// ErrCannotRetry is returned by Retry for uploads that did not fail, or
+// whose video is no longer kept
+var ErrCannotRetry = errors.New("this upload can no longer be retried, please upload the video again")
+
+// ErrJobActive is returned by Retry while the job is still queued or running
+var ErrJobActive = errors.New("the upload job is still running")
+
+// pruneInterval is how often staged videos are checked for expiry
+const pruneInterval = 10 * time.Minute
+
+// Submission describes a video and the accounts it should be uploaded to
+type Submission struct {
+	UserID   string
+	Filename string
+	Size     int64
+	File     io.Reader
+	Targets  []Target
+	Metadata services.Metadata
+
+	Attachments []Attachment
+}
+
+// Attachment is an extra file submitted with the video in the named form
+// field, such as a thumbnail image
+type Attachment struct {
+	Field    string
+	Filename string
+	Size     int64
+	File     io.Reader
+}
+
+// attachmentField matches the form fields attachments may come from; the
+// field name becomes part of the staged file name
+var attachmentField = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*$`)
+
+// Target is a connected platform account a video is sent to. An empty
+// AccountID means the user has no account connected on the platform.
+type Target struct {
+	Platform    string
+	AccountID   string
+	AccountName string
+}
+
+// task is a queued job together with the data needed to run it
+type task struct {
+	jobID      string
+	stagedPath string
+	metadata   services.Metadata
+}
+
+// Manager queues upload jobs and runs them on a pool of background workers.
+// Active jobs are kept in memory; every change is written to the store,
+// which also serves jobs once they have finished.
+type Manager struct {
+	mu      sync.RWMutex
+	jobs    map[string]*models.Job
+	cancels map[string]context.CancelFunc // Keyed by cancelKey
+	subs    map[string]map[chan Event]struct{}
+	queue   chan task
+	store   *storage.Store
+	ctx     context.Context
+
+	stagingDir string        // Staged videos and upload checkpoints, see staging.go
+	retention  time.Duration // How long videos of jobs with failed uploads are kept
+}
+
+// Options configures a Manager
+type Options struct {
+	Workers    int           // Number of jobs uploaded concurrently
+	QueueSize  int           // Number of jobs that may wait for a free worker
+	StagingDir string        // Where videos are kept until their uploads have finished
+	Retention  time.Duration // How long videos of jobs with failed uploads are kept for retrying
+}
+
+// NewManager starts a manager with the configured number of workers.
+// Videos are staged in opts.StagingDir, where jobs interrupted by a
+// restart pick them up again.
+func NewManager(ctx context.Context, store *storage.Store, opts Options) (*Manager, error) {
+	if err := os.MkdirAll(opts.StagingDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	m := &Manager{
+		jobs:       make(map[string]*models.Job),
+		cancels:    make(map[string]context.CancelFunc),
+		subs:       make(map[string]map[chan Event]struct{}),
+		queue:      make(chan task, opts.QueueSize),
+		store:      store,
+		ctx:        ctx,
+		stagingDir: opts.StagingDir,
+		retention:  opts.Retention,
+	}
+	resumed := m.resumeInterrupted()
+	for i := 0; i < opts.Workers; i++ {
+		go m.worker()
+	}
+	go m.pruneLoop()
+
+	// Resumed jobs do not count against the queue size
+	go func() {
+		for _, t := range resumed {
+			select {
+			case m.queue <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return m, nil
+}
+
+// resumeInterrupted requeues jobs left unfinished by a previous run whose
+// staged video is still there. Uploads that had not started are run again,
+// as are uploads that saved a checkpoint to continue from; the others are
+// marked as failed, since starting them over could post the video twice.
+func (m *Manager) resumeInterrupted() []task {
+	jobs, err := m.store.ListJobs("", 0)
+	if err != nil {
+		log.Printf("Failed to load upload history: %v", err)
+		return nil
+	}
+
+	var resumed []task
+	for _, job := range jobs {
+		if job.Done() {
+			continue
+		}
+
+		stagedPath := m.stagedPath(job.ID, job.Filename)
+		_, err := os.Stat(stagedPath)
+		staged := err == nil
+
+		pending := 0
+		for i := range job.Result.Platforms {
+			res := &job.Result.Platforms[i]
+			if res.Done() {
+				continue
+			}
+			if staged && (res.Status == models.PlatformPending || newCheckpoint(stagedPath, res.Key()).exists()) {
+				res.Status = models.PlatformPending
+				res.Progress = nil
+				pending++
+				continue
+			}
+			res.Status = models.PlatformFailed
+			res.Error = "Upload interrupted by a server restart"
+			res.ErrorKind = models.ErrorTransient
+		}
+
+		if pending > 0 {
+			log.Printf("Resuming job %s for %d account(s)", job.ID, pending)
+			job.Status = models.JobQueued
+			m.jobs[job.ID] = &job
+			resumed = append(resumed, m.taskFor(&job))
+		} else if m.complete(&job) && !staged {
+			job.RetainUntil = time.Time{} // Nothing left to retry from
+		}
+		if err := m.store.SaveJob(job); err != nil {
+			log.Printf("Failed to save interrupted job %s: %v", job.ID, err)
+		}
+	}
+
+	m.pruneStaged()
+	return resumed
+}
+
+// taskFor returns the task that runs the pending uploads of a job whose
+// video is already staged
+func (m *Manager) taskFor(job *models.Job) task {
+	return task{
+		jobID:      job.ID,
+		stagedPath: m.stagedPath(job.ID, job.Filename),
+		metadata: services.Metadata{
+			UserID:      job.UserID,
+			MainCaption: job.MainCaption,
+			Values:      url.Values(job.Fields),
+		},
+	}
+}
+
+// Submit validates the submission, stages the video to disk and enqueues
+// the job. Platforms that fail validation are marked as failed immediately.
+func (m *Manager) Submit(sub Submission) (models.Job, error) {
+	id, err := newID()
+	if err != nil {
+		return models.Job{}, fmt.Errorf("failed to generate job ID: %w", err)
+	}
+
+	stagedPath := m.stagedPath(id, sub.Filename)
+	if err := stage(sub.File, stagedPath); err != nil {
+		return models.Job{}, err
+	}
+
+	job := &models.Job{
+		ID:          id,
+		UserID:      sub.UserID,
+		Status:      models.JobQueued,
+		Filename:    sub.Filename,
+		Size:        sub.Size,
+		MainCaption: sub.Metadata.MainCaption,
+		Fields:      sub.Metadata.Values,
+		CreatedAt:   time.Now(),
+	}
+	if info, err := probe.Video(stagedPath); err != nil {
+		log.Printf("Job %s: could not read the length and size of %s: %v", id, sub.Filename, err)
+	} else {
+		job.Video = &info
+	}
+	if err := m.stageAttachments(job, sub.Attachments); err != nil {
+		m.unstage(id)
+		return models.Job{}, err
+	}
+
+	// The video itself is not read during validation
+	media := m.mediaFor(job)
+	for _, target := range sub.Targets {
+		if !slices.Contains(job.Platforms, target.Platform) {
+			job.Platforms = append(job.Platforms, target.Platform)
+		}
+
+		platform, ok := services.Lookup(target.Platform)
+		if !ok {
+			log.Printf("Job %s: skipping unknown platform %q", id, target.Platform)
+			job.Result.Add(models.PlatformResult{
+				Platform:    target.Platform,
+				DisplayName: target.Platform,
+				Status:      models.PlatformFailed,
+				Error:       "Unknown platform",
+			})
+			continue
+		}
+
+		res := services.NewResult(platform)
+		res.AccountID = target.AccountID
+		res.AccountName = target.AccountName
+		if target.AccountID == "" {
+			res.Status = models.PlatformFailed
+			res.Error = fmt.Sprintf("No %s account connected, connect one on the Connections page", platform.DisplayName())
+			res.ErrorKind = models.ErrorAuth
+		} else if err := platform.Validate(media, sub.Metadata); err != nil {
+			log.Printf("Job %s: skipping %s upload: %v", id, platform.DisplayName(), err)
+			res.Status = models.PlatformFailed
+			res.Error = err.Error()
+			res.ErrorKind = models.ErrorValidation
+		} else if w, ok := platform.(services.Warner); ok {
+			res.Warnings = w.Warnings(media, sub.Metadata)
+		}
+		job.Result.Add(res)
+	}
+
+	// Hold the lock while enqueueing so a worker cannot update the job
+	// before its initial state has been saved
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	select {
+	case m.queue <- task{jobID: id, stagedPath: stagedPath, metadata: sub.Metadata}:
+	default:
+		m.unstage(id)
+		return models.Job{}, ErrQueueFull
+	}
+
+	m.jobs[id] = job
+	snapshot := copyJob(job)
+	if err := m.store.SaveJob(snapshot); err != nil {
+		log.Printf("Job %s: failed to save: %v", id, err)
+	}
+
+	log.Printf("Job %s queued for %d account(s)", id, len(sub.Targets))
+	return snapshot, nil
+}
+
+// Get returns a snapshot of the job with the given ID, looking in the
+// store for jobs that are no longer active
+func (m *Manager) Get(id string) (models.Job, bool) {
+	m.mu.RLock()
+	job, ok := m.jobs[id]
+	if ok {
+		snapshot := copyJob(job)
+		m.mu.RUnlock()
+		return snapshot, true
+	}
+	m.mu.RUnlock()
+
+	stored, err := m.store.GetJob(id)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Failed to load job %s: %v", id, err)
+		}
+		return models.Job{}, false
+	}
+	return stored, true
+}
+
+// History returns up to limit past jobs of the user, newest first
+func (m *Manager) History(userID string, limit int) ([]models.Job, error) {
+	return m.store.ListJobs(userID, limit)
+}
+
+// worker runs queued jobs until the manager's context is cancelled
+func (m *Manager) worker() {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case t := <-m.queue:
+			m.run(t)
+		}
+	}
+}
+
+// stageAttachments stages the extra files of a submission and records
+// them in the job
+func (m *Manager) stageAttachments(job *models.Job, attachments []Attachment) error {
+	for _, a := range attachments {
+		if !attachmentField.MatchString(a.Field) {
+			return fmt.Errorf("invalid attachment field %q", a.Field)
+		}
+		path := m.attachmentPath(job.ID, a.Field, a.Filename)
+		if err := stage(a.File, path); err != nil {
+			return err
+		}
+		contentType, err := detectContentType(path)
+		if err != nil {
+			return fmt.Errorf("failed to read staged %s: %w", a.Filename, err)
+		}
+
+		if job.Attachments == nil {
+			job.Attachments = make(map[string]models.Attachment)
+		}
+		job.Attachments[a.Field] = models.Attachment{Filename: a.Filename, Size: a.Size, ContentType: contentType}
+	}
+	return nil
+}
+
+// run uploads the staged video to every pending platform of the job in
+// parallel. Each platform gets its own context so it can be cancelled alone.
+func (m *Manager) run(t task) {
+	m.update(t.jobID, func(job *models.Job) {
+		job.Status = models.JobRunning
+		job.StartedAt = time.Now()
+	})
+
+	job, _ := m.Get(t.jobID)
+	media := m.mediaFor(&job)
+
+	var wg sync.WaitGroup
+	for i, res := range job.Result.Platforms {
+		if res.Done() {
+			continue
+		}
+
+		platform, ok := services.Lookup(res.Platform)
+		if !ok {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(m.ctx)
+		ctx = services.WithProgress(ctx, m.progressReporter(t.jobID, i))
+		ctx = services.WithCheckpoint(ctx, newCheckpoint(t.stagedPath, res.Key()))
+		m.mu.Lock()
+		m.cancels[cancelKey(t.jobID, res.Key())] = cancel
+		m.mu.Unlock()
+
+		meta := t.metadata
+		meta.AccountID = res.AccountID
+
+		wg.Add(1)
+		go func(i int, key string, platform services.Platform) {
+			defer wg.Done()
+			defer m.release(t.jobID, key)
+
+			m.update(t.jobID, func(job *models.Job) {
+				job.Result.Platforms[i].Status = models.PlatformRunning
+				job.Result.Platforms[i].Progress = &models.Progress{Phase: "starting"}
+			})
+
+			final := platform.Upload(ctx, media, meta)
+			if !final.Success && ctx.Err() != nil {
+				final.Error = "Upload cancelled"
+				final.ErrorKind = ""
+			}
+			final.AccountID = meta.AccountID
+			final.AccountName = res.AccountName
+			final.Warnings = res.Warnings
+			final = finish(final)
+
+			m.update(t.jobID, func(job *models.Job) {
+				job.Result.Platforms[i] = final
+			})
+		}(i, res.Key(), platform)
+	}
+	wg.Wait()
+
+	retain := false
+	m.update(t.jobID, func(job *models.Job) {
+		retain = m.complete(job)
+	})
+	if !retain {
+		m.unstage(t.jobID)
+	}
+
+	// Finished jobs are served from the store from now on
+	m.mu.Lock()
+	m.closeSubscribers(t.jobID)
+	delete(m.jobs, t.jobID)
+	m.mu.Unlock()
+	log.Printf("Job %s completed", t.jobID)
+}
+
+// complete marks the job as finished and keeps its video for the
+// retention period if any failed upload can be retried. It reports
+// whether the video is kept.
+func (m *Manager) complete(job *models.Job) bool {
+	job.Status = models.JobCompleted
+	job.FinishedAt = time.Now()
+	job.RetainUntil = job.FinishedAt.Add(m.retention)
+	if !slices.ContainsFunc(job.Result.Platforms, job.CanRetry) {
+		job.RetainUntil = time.Time{}
+	}
+	return !job.RetainUntil.IsZero()
+}
+
+// Retry runs the failed upload of a finished job to the account
+// identified by target (see models.PlatformResult.Key) again, using the
+// kept copy of the video
+func (m *Manager) Retry(jobID, target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, active := m.jobs[jobID]; active {
+		return ErrJobActive
+	}
+	job, err := m.store.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(job.Result.Platforms, func(res models.PlatformResult) bool { return res.Key() == target })
+	if i < 0 || !job.CanRetry(job.Result.Platforms[i]) {
+		return ErrCannotRetry
+	}
+	failed := job.Result.Platforms[i]
+	platform, ok := services.Lookup(failed.Platform)
+	if !ok {
+		return ErrCannotRetry
+	}
+	t := m.taskFor(&job)
+	if _, err := os.Stat(t.stagedPath); err != nil {
+		return ErrCannotRetry
+	}
+
+	res := services.NewResult(platform)
+	res.AccountID = failed.AccountID
+	res.AccountName = failed.AccountName
+	res.Warnings = failed.Warnings
+	job.Result.Platforms[i] = res
+	job.Status = models.JobQueued
+	job.FinishedAt = time.Time{}
+
+	select {
+	case m.queue <- t:
+	default:
+		return ErrQueueFull
+	}
+
+	m.jobs[jobID] = &job
+	if err := m.store.SaveJob(copyJob(&job)); err != nil {
+		log.Printf("Job %s: failed to save: %v", jobID, err)
+	}
+	log.Printf("Job %s: retrying upload %s", jobID, target)
+	return nil
+}
+
+// Cancel stops the upload of a running job to the account identified by
+// target (see models.PlatformResult.Key), or every upload of the job when
+// target is empty. It reports whether anything was cancelled.
+func (m *Manager) Cancel(jobID, target string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cancelled := false
+	for key, cancel := range m.cancels {
+		if key == cancelKey(jobID, target) || (target == "" && strings.HasPrefix(key, jobID+"/")) {
+			log.Printf("Cancelling upload %s", key)
+			cancel()
+			cancelled = true
+		}
+	}
+	return cancelled
+}
+
+// release drops the cancel function of a finished platform upload
+func (m *Manager) release(jobID, target string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := cancelKey(jobID, target)
+	if cancel, ok := m.cancels[key]; ok {
+		cancel()
+		delete(m.cancels, key)
+	}
+}
+
+// cancelKey identifies a single platform upload within a job
+func cancelKey(jobID, target string) string {
+	return jobID + "/" + target
+}
+
+// update applies fn to the job and saves it while holding the lock,
+// so the store always sees changes in order
+func (m *Manager) update(id string, fn func(job *models.Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+	fn(job)
+	if err := m.store.SaveJob(copyJob(job)); err != nil {
+		log.Printf("Job %s: failed to save: %v", id, err)
+	}
+	m.publish(job)
+}
+
+// finish sets the final status of a platform result from its outcome
+func finish(res models.PlatformResult) models.PlatformResult {
+	if res.Success {
+		res.Status = models.PlatformSucceeded
+	} else {
+		res.Status = models.PlatformFailed
+	}
+	return res
+}
+
+// copyJob returns a copy of the job that is safe to use without the lock
+func copyJob(job *models.Job) models.Job {
+	c := *job
+	c.Result.Platforms = append([]models.PlatformResult(nil), job.Result.Platforms...)
+	return c
+}
+
+// newID returns a random hex job identifier
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
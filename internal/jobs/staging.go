@@ -0,0 +1,224 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"uploader/internal/models"
+	"uploader/internal/services"
+	"uploader/internal/storage"
+)
+
+// stagedPath returns where the video of a job is kept until every
+// platform upload has finished
+func (m *Manager) stagedPath(jobID, filename string) string {
+	return filepath.Join(m.stagingDir, jobID+filepath.Ext(filename))
+}
+
+// attachmentPath returns where an extra file submitted in the given form
+// field is kept. Like the video, its name starts with the job ID.
+func (m *Manager) attachmentPath(jobID, field, filename string) string {
+	return filepath.Join(m.stagingDir, jobID+"."+field+strings.ToLower(filepath.Ext(filename)))
+}
+
+// mediaFor describes the staged video and attachments of a job
+func (m *Manager) mediaFor(job *models.Job) *services.Media {
+	media := &services.Media{
+		Filename: job.Filename,
+		Size:     job.Size,
+		Path:     m.stagedPath(job.ID, job.Filename),
+		Video:    job.Video,
+	}
+	for field, a := range job.Attachments {
+		if media.Attachments == nil {
+			media.Attachments = make(map[string]*services.Attachment)
+		}
+		media.Attachments[field] = &services.Attachment{Attachment: a, Path: m.attachmentPath(job.ID, field, a.Filename)}
+	}
+	return media
+}
+
+// detectContentType sniffs the type of a staged file from its first bytes,
+// since the type sent by the browser cannot be trusted
+func detectContentType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// stage copies the uploaded video to path so it outlives the request
+func stage(src io.Reader, path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, src); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to stage upload: %w", err)
+	}
+	return nil
+}
+
+// stagingGrace protects files that are still being written, before the
+// job they belong to has been saved
+const stagingGrace = time.Hour
+
+// unstage removes the staged video of a job together with the
+// checkpoints of its uploads
+func (m *Manager) unstage(jobID string) {
+	m.removeStaged(func(id string, _ fs.DirEntry) bool { return id == jobID })
+}
+
+// pruneLoop periodically removes staged videos that are no longer needed
+// until the manager's context is cancelled
+func (m *Manager) pruneLoop() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.pruneStaged()
+		}
+	}
+}
+
+// pruneStaged deletes the staged files of finished jobs whose retention
+// has passed, and of jobs that no longer exist. Videos of unfinished jobs
+// are kept so they can be resumed.
+func (m *Manager) pruneStaged() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	expired := make(map[string]bool)
+	m.removeStaged(func(id string, entry fs.DirEntry) bool {
+		if done, ok := expired[id]; ok {
+			return done
+		}
+
+		job, err := m.store.GetJob(id)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			info, err := entry.Info()
+			if err != nil || now.Sub(info.ModTime()) < stagingGrace {
+				return false
+			}
+			expired[id] = true
+		case err != nil:
+			log.Printf("Failed to load job %s: %v", id, err)
+			expired[id] = false
+		default:
+			expired[id] = job.Done() && now.After(job.RetainUntil)
+		}
+		if expired[id] {
+			log.Printf("Removing staged files of job %s", id)
+		}
+		return expired[id]
+	})
+}
+
+// removeStaged deletes the files in the staging directory that match.
+// Every file name starts with the ID of the job it belongs to.
+func (m *Manager) removeStaged(match func(jobID string, entry fs.DirEntry) bool) {
+	entries, err := os.ReadDir(m.stagingDir)
+	if err != nil {
+		log.Printf("Failed to read staging directory: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		id, _, _ := strings.Cut(entry.Name(), ".")
+		if !match(id, entry) {
+			continue
+		}
+		path := filepath.Join(m.stagingDir, entry.Name())
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to remove staged file %s: %v", path, err)
+		}
+	}
+}
+
+// checkpointExt is the file extension of saved upload state
+const checkpointExt = ".checkpoint"
+
+// fileCheckpoint keeps the state of one platform upload in a JSON file
+// next to the staged video
+type fileCheckpoint struct {
+	path string
+}
+
+// newCheckpoint returns the checkpoint of the upload identified by target
+// (see models.PlatformResult.Key)
+func newCheckpoint(stagedPath, target string) fileCheckpoint {
+	return fileCheckpoint{path: stagedPath + "." + target + checkpointExt}
+}
+
+// exists reports whether any state has been saved
+func (c fileCheckpoint) exists() bool {
+	_, err := os.Stat(c.path)
+	return err == nil
+}
+
+// Load implements services.Checkpoint
+func (c fileCheckpoint) Load(v interface{}) (bool, error) {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to decode checkpoint %s: %w", c.path, err)
+	}
+	return true, nil
+}
+
+// Save implements services.Checkpoint. The file is replaced atomically so
+// a crash never leaves half-written state behind.
+func (c fileCheckpoint) Save(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Clear implements services.Checkpoint
+func (c fileCheckpoint) Clear() error {
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
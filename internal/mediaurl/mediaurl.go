@@ -0,0 +1,77 @@
+package mediaurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TTL is how long a signed media URL stays valid. Platforms that fetch the
+// video themselves, like Instagram, do so while the container is created.
+const TTL = 2 * time.Hour
+
+// Prefix is the path the media handler is mounted on
+const Prefix = "/media/"
+
+var (
+	// ErrInvalid is returned for URLs with a missing or wrong signature
+	ErrInvalid = errors.New("invalid media URL signature")
+	// ErrExpired is returned for URLs whose lifetime has passed
+	ErrExpired = errors.New("media URL has expired")
+)
+
+// Signer creates and checks public URLs for staged videos, so platforms
+// that download the video from a URL can fetch it without an account
+type Signer struct {
+	baseURL string
+	key     []byte
+}
+
+// NewSigner returns a signer for URLs under baseURL. The signing key is
+// derived from secret, so URLs stay valid across restarts.
+func NewSigner(baseURL string, secret []byte) *Signer {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("uploader media URL"))
+	return &Signer{baseURL: strings.TrimSuffix(baseURL, "/"), key: mac.Sum(nil)}
+}
+
+// URL returns a public URL for the staged file with the given name that
+// expires after TTL
+func (s *Signer) URL(name string) string {
+	expires := strconv.FormatInt(time.Now().Add(TTL).Unix(), 10)
+	q := url.Values{"expires": {expires}, "sig": {s.sign(name, expires)}}
+	return s.baseURL + Prefix + url.PathEscape(name) + "?" + q.Encode()
+}
+
+// Verify checks the signature and expiry of a request for the named file
+func (s *Signer) Verify(name, expires, sig string) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return ErrInvalid
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.sign(name, expires))) {
+		return ErrInvalid
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	if time.Now().After(time.Unix(unix, 0)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// sign returns the hex encoded signature of a name and expiry time
+func (s *Signer) sign(name, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%s", name, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"uploader/internal/auth"
+)
+
+// LoadUser returns a middleware that looks up the user of the session
+// cookie, if any, and adds them to the request context
+func LoadUser(svc *auth.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cookie, err := r.Cookie(auth.SessionCookie)
+			if err == nil && cookie.Value != "" {
+				user, err := svc.UserForSession(cookie.Value)
+				switch {
+				case err == nil:
+					r = r.WithContext(auth.WithUser(r.Context(), user))
+				case !errors.Is(err, auth.ErrNoSession):
+					log.Printf("Failed to load session: %v", err)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireUser rejects requests without a signed-in user. Page loads are
+// redirected to the sign-in page, HTMX requests are told to navigate there
+// and anything else gets a 401.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.UserFrom(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		signIn := "/signin?next=" + url.QueryEscape(r.URL.RequestURI())
+		switch {
+		case r.Header.Get("HX-Request") == "true":
+			w.Header().Set("HX-Redirect", signIn)
+			http.Error(w, "Please sign in", http.StatusUnauthorized)
+		case r.Method == http.MethodGet:
+			http.Redirect(w, r, signIn, http.StatusSeeOther)
+		default:
+			http.Error(w, "Please sign in", http.StatusUnauthorized)
+		}
+	})
+}
@@ -1,22 +1,199 @@
 package models
 
+import (
+	"fmt"
+	"time"
+)
+
+// PlatformStatus is the state of a single platform upload within a job
+type PlatformStatus string
+
+const (
+	PlatformPending   PlatformStatus = "pending"
+	PlatformRunning   PlatformStatus = "running"
+	PlatformSucceeded PlatformStatus = "succeeded"
+	PlatformFailed    PlatformStatus = "failed"
+)
+
+// PlatformResult represents the result of uploading a video to a single platform
+type PlatformResult struct {
+	Platform    string         `json:"platform"`
+	DisplayName string         `json:"displayName"`
+	AccountID   string         `json:"accountId,omitempty"`   // Connected account the video goes to
+	AccountName string         `json:"accountName,omitempty"` // Channel or username of that account
+	Status      PlatformStatus `json:"status"`
+	Success     bool           `json:"success"`
+	ID          string         `json:"id,omitempty"`      // Video, reel or post ID returned by the platform
+	IDLabel     string         `json:"idLabel,omitempty"` // Label shown next to the ID, e.g. "Video ID"
+	URL         string         `json:"url,omitempty"`     // Public link to the uploaded video, if known
+	Error       string         `json:"error,omitempty"`
+	ErrorKind   ErrorKind      `json:"errorKind,omitempty"`   // Why the upload failed, if known
+	Progress    *Progress      `json:"progress,omitempty"`    // Latest progress report while the upload runs
+	SubStatuses []SubStatus    `json:"subStatuses,omitempty"` // Follow-up steps after the video was uploaded
+	Warnings    []string       `json:"warnings,omitempty"`    // Problems found before uploading that do not stop it
+}
+
+// SubStatus is the outcome of a follow-up step of an upload, such as
+// setting a thumbnail. A failed step does not fail the upload.
+type SubStatus struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ErrorKind classifies why a platform upload failed
+type ErrorKind string
+
+const (
+	ErrorTransient   ErrorKind = "transient"    // Network problem or platform outage
+	ErrorRateLimited ErrorKind = "rate_limited" // Too many requests in a short time
+	ErrorQuota       ErrorKind = "quota"        // API or posting quota used up
+	ErrorAuth        ErrorKind = "auth"         // Token expired, revoked or missing a permission
+	ErrorValidation  ErrorKind = "validation"   // The video or its details were rejected
+)
+
+// Retryable reports whether the same upload may succeed if tried again later
+func (k ErrorKind) Retryable() bool {
+	return k == ErrorTransient || k == ErrorRateLimited || k == ErrorQuota
+}
+
+// Hint tells the user what to do about the failure
+func (k ErrorKind) Hint() string {
+	switch k {
+	case ErrorTransient:
+		return "Temporary problem, retrying should work"
+	case ErrorRateLimited:
+		return "Too many requests, retry in a few minutes"
+	case ErrorQuota:
+		return "Quota used up, retry once it resets"
+	case ErrorAuth:
+		return "Reconnect the account on the Connections page"
+	case ErrorValidation:
+		return "Fix the video or its details and upload again"
+	}
+	return ""
+}
+
+// Progress is a progress report from a running platform upload
+type Progress struct {
+	Phase       string `json:"phase"`                 // e.g. "uploading", "processing", "publishing"
+	BytesSent   int64  `json:"bytesSent,omitempty"`   // Bytes of the video sent so far
+	TotalBytes  int64  `json:"totalBytes,omitempty"`  // Size of the video
+	Chunk       int    `json:"chunk,omitempty"`       // Index of the last chunk sent, starting at 1
+	TotalChunks int    `json:"totalChunks,omitempty"` // Number of chunks the video is split into
+	Status      string `json:"status,omitempty"`      // Status reported by the platform, e.g. an Instagram container status
+}
+
+// Percent returns how much of the video has been sent, from 0 to 100
+func (p *Progress) Percent() int {
+	if p == nil || p.TotalBytes <= 0 {
+		return 0
+	}
+	return int(p.BytesSent * 100 / p.TotalBytes)
+}
+
 // UploadResult represents the result of uploading a video to various platforms
 type UploadResult struct {
-	YouTube struct {
-		Success bool   `json:"success"`
-		VideoID string `json:"videoId,omitempty"`
-		Error   string `json:"error,omitempty"`
-	} `json:"youtube"`
-	Instagram struct {
-		Success bool   `json:"success"`
-		ReelID  string `json:"reelId,omitempty"`
-		Error   string `json:"error,omitempty"`
-	} `json:"instagram"`
-	TikTok struct {
-		Success bool   `json:"success"`
-		PostID  string `json:"postId,omitempty"`
-		Error   string `json:"error,omitempty"`
-	} `json:"tiktok"`
+	Platforms []PlatformResult `json:"platforms"`
+}
+
+// Add appends the result for a single platform
+func (u *UploadResult) Add(res PlatformResult) {
+	u.Platforms = append(u.Platforms, res)
+}
+
+// Done reports whether the platform upload has reached a final state
+func (p PlatformResult) Done() bool {
+	return p.Status == PlatformSucceeded || p.Status == PlatformFailed
+}
+
+// Key identifies the upload within its job, since a platform can appear
+// once for every account the video is sent to
+func (p PlatformResult) Key() string {
+	if p.AccountID == "" {
+		return p.Platform
+	}
+	return p.Platform + "-" + p.AccountID
+}
+
+// JobStatus is the overall state of an upload job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+)
+
+// Job is a video submission that is uploaded to its platforms in the background
+type Job struct {
+	ID          string                `json:"id"`
+	UserID      string                `json:"userId"` // Account that submitted the upload
+	Status      JobStatus             `json:"status"`
+	Filename    string                `json:"filename"`
+	Size        int64                 `json:"size"`
+	MainCaption string                `json:"mainCaption"`
+	Fields      map[string][]string   `json:"fields,omitempty"`      // Platform-specific form fields such as captions and titles
+	Platforms   []string              `json:"platforms"`             // Platforms selected on the upload form
+	Attachments map[string]Attachment `json:"attachments,omitempty"` // Extra files keyed by form field, e.g. a thumbnail
+	Video       *VideoInfo            `json:"video,omitempty"`       // Read from the video file; nil if it could not be probed
+	Result      UploadResult          `json:"result"`
+	CreatedAt   time.Time             `json:"createdAt"`
+	StartedAt   time.Time             `json:"startedAt"`
+	FinishedAt  time.Time             `json:"finishedAt"`
+	RetainUntil time.Time             `json:"retainUntil"` // The video is kept until then so failed uploads can be retried
+}
+
+// Attachment is an extra file submitted with a video, staged next to it
+type Attachment struct {
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"contentType"` // Detected from the file contents
+}
+
+// VideoInfo describes the picture of a video as it is displayed
+type VideoInfo struct {
+	Duration time.Duration `json:"duration"`
+	Width    int           `json:"width"`  // Already rotated as the player shows it
+	Height   int           `json:"height"` // Already rotated as the player shows it
+}
+
+// Vertical reports whether the video is at least as tall as it is wide
+func (v VideoInfo) Vertical() bool {
+	return v.Height >= v.Width
+}
+
+// Length renders the duration as minutes and seconds, e.g. "1:05"
+func (v VideoInfo) Length() string {
+	seconds := int(v.Duration.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// Done reports whether every platform upload in the job has finished
+func (j *Job) Done() bool {
+	return j.Status == JobCompleted
+}
+
+// CanRetry reports whether the failed upload res of the finished job can be
+// run again from the kept copy of the video. Uploads rejected for their
+// input would fail the same way again.
+func (j *Job) CanRetry(res PlatformResult) bool {
+	return j.Done() && time.Now().Before(j.RetainUntil) &&
+		res.Status == PlatformFailed && res.AccountID != "" && res.ErrorKind != ErrorValidation
+}
+
+// User is a local account of the uploader app
+type User struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash []byte    `json:"passwordHash"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Session is a signed-in browser session
+type Session struct {
+	UserID    string    `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
 }
 
 // InstagramTokenResponse represents the OAuth token response from Instagram
@@ -27,16 +204,71 @@ type InstagramTokenResponse struct {
 
 // InstagramMediaResponse represents the response from Instagram media endpoints
 type InstagramMediaResponse struct {
-	ID      string `json:"id"`
-	Status  string `json:"status"`
-	MediaID string `json:"media_id"`
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	StatusCode string `json:"status_code"` // Container state, e.g. IN_PROGRESS or FINISHED
+	MediaID    string `json:"media_id"`
+	Permalink  string `json:"permalink"`
 }
 
 // TikTokTokenResponse represents the OAuth token response from TikTok
 type TikTokTokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	ExpiresIn    int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token"`
-	OpenID       string `json:"open_id"`
-	Scope        string `json:"scope"`
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int    `json:"refresh_expires_in"`
+	OpenID           string `json:"open_id"`
+	Scope            string `json:"scope"`
+}
+
+// YouTubeCategory is a video category that can be assigned to uploads
+type YouTubeCategory struct {
+	ID    string
+	Title string
+}
+
+// YouTubePlaylist is a playlist of a connected channel
+type YouTubePlaylist struct {
+	ID    string
+	Title string
+}
+
+// TikTokCreatorInfo represents the response data of TikTok's creator_info
+// query: what the creator may currently post with
+type TikTokCreatorInfo struct {
+	Username            string   `json:"creator_username"`
+	Nickname            string   `json:"creator_nickname"`
+	PrivacyLevelOptions []string `json:"privacy_level_options"` // e.g. PUBLIC_TO_EVERYONE, MUTUAL_FOLLOW_FRIENDS, FOLLOWER_OF_CREATOR, SELF_ONLY
+	CommentDisabled     bool     `json:"comment_disabled"`
+	DuetDisabled        bool     `json:"duet_disabled"`
+	StitchDisabled      bool     `json:"stitch_disabled"`
+	MaxVideoDuration    int      `json:"max_video_post_duration_sec"`
+}
+
+// InstagramLongLivedTokenResponse represents the response of the Instagram
+// long-lived token exchange and refresh endpoints
+type InstagramLongLivedTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Token is a stored OAuth token for one platform. The JSON layout matches
+// oauth2.Token so tokens saved by either can be read by both.
+type Token struct {
+	AccessToken   string    `json:"access_token"`
+	TokenType     string    `json:"token_type,omitempty"`
+	RefreshToken  string    `json:"refresh_token,omitempty"`
+	Expiry        time.Time `json:"expiry,omitempty"`
+	RefreshExpiry time.Time `json:"refresh_expiry,omitempty"` // When the refresh token stops working, if the platform says
+	UserID        string    `json:"user_id,omitempty"`        // YouTube channel ID, Instagram user_id or TikTok open_id
+	Name          string    `json:"name,omitempty"`           // Channel title or username, for display
+	Scope         string    `json:"scope,omitempty"`
+}
+
+// Account is a platform account connected by a user
+type Account struct {
+	Platform string `json:"platform"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
 }
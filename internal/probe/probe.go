@@ -0,0 +1,213 @@
+// Package probe reads the duration and picture size of MP4 and QuickTime
+// videos from their box structure, without decoding any media
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"uploader/internal/models"
+)
+
+// ErrNotVideo is returned for files without a movie header or video track
+var ErrNotVideo = errors.New("no video track found")
+
+// box is an MP4 box whose contents start at offset
+type box struct {
+	typ    string
+	offset int64
+	size   int64 // Size of the contents, without the header
+}
+
+// Video returns the duration and displayed size of the video at path
+func Video(path string) (models.VideoInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return models.VideoInfo{}, fmt.Errorf("failed to open video: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return models.VideoInfo{}, fmt.Errorf("failed to read video: %w", err)
+	}
+	return read(file, stat.Size())
+}
+
+// read probes a video of the given size
+func read(r io.ReaderAt, size int64) (models.VideoInfo, error) {
+	var info models.VideoInfo
+
+	// The movie box may come before or after the media data
+	moov, err := find(r, 0, size, "moov")
+	if err != nil {
+		return info, err
+	}
+	children, err := boxes(r, moov.offset, moov.size)
+	if err != nil {
+		return info, err
+	}
+
+	for _, b := range children {
+		switch b.typ {
+		case "mvhd":
+			if info.Duration, err = movieDuration(r, b); err != nil {
+				return info, err
+			}
+		case "trak":
+			width, height, ok, err := videoTrack(r, b)
+			if err != nil {
+				return info, err
+			}
+			if ok && info.Width == 0 {
+				info.Width, info.Height = width, height
+			}
+		}
+	}
+	if info.Duration <= 0 || info.Width == 0 || info.Height == 0 {
+		return info, ErrNotVideo
+	}
+	return info, nil
+}
+
+// movieDuration reads the duration from the movie header
+func movieDuration(r io.ReaderAt, mvhd box) (time.Duration, error) {
+	data, err := contents(r, mvhd, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	var timescale, duration uint64
+	if data[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+		duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("invalid movie header")
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), nil
+}
+
+// videoTrack returns the displayed size of a track, and false if it is not
+// a video track
+func videoTrack(r io.ReaderAt, trak box) (width, height int, ok bool, err error) {
+	children, err := boxes(r, trak.offset, trak.size)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	var tkhd *box
+	video := false
+	for i, b := range children {
+		switch b.typ {
+		case "tkhd":
+			tkhd = &children[i]
+		case "mdia":
+			hdlr, err := find(r, b.offset, b.size, "hdlr")
+			if err != nil {
+				continue
+			}
+			data, err := contents(r, hdlr, 12)
+			if err != nil {
+				return 0, 0, false, err
+			}
+			video = string(data[8:12]) == "vide"
+		}
+	}
+	if !video || tkhd == nil {
+		return 0, 0, false, nil
+	}
+
+	data, err := contents(r, *tkhd, 84)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	// Version 1 headers have 64-bit times and duration
+	rest := data[24:]
+	if data[0] == 1 {
+		if data, err = contents(r, *tkhd, 96); err != nil {
+			return 0, 0, false, err
+		}
+		rest = data[36:]
+	}
+
+	// rest holds reserved fields, layer, group and volume (16 bytes), the
+	// transformation matrix (36 bytes) and the 16.16 fixed point size
+	matrix := rest[16:52]
+	width = int(binary.BigEndian.Uint32(rest[52:56]) >> 16)
+	height = int(binary.BigEndian.Uint32(rest[56:60]) >> 16)
+
+	// Phones record upright video as landscape frames rotated by 90 or 270
+	// degrees, which shows as zeros on the matrix diagonal
+	a := int32(binary.BigEndian.Uint32(matrix[0:4]))
+	d := int32(binary.BigEndian.Uint32(matrix[16:20]))
+	if a == 0 && d == 0 {
+		width, height = height, width
+	}
+	return width, height, true, nil
+}
+
+// find returns the first box of the given type among the boxes in the range
+func find(r io.ReaderAt, offset, size int64, typ string) (box, error) {
+	children, err := boxes(r, offset, size)
+	if err != nil {
+		return box{}, err
+	}
+	for _, b := range children {
+		if b.typ == typ {
+			return b, nil
+		}
+	}
+	return box{}, ErrNotVideo
+}
+
+// boxes lists the boxes stored one after another in the range
+func boxes(r io.ReaderAt, offset, size int64) ([]box, error) {
+	var list []box
+	end := offset + size
+	header := make([]byte, 16)
+	for offset+8 <= end {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, fmt.Errorf("failed to read video: %w", err)
+		}
+		b := box{typ: string(header[4:8]), offset: offset + 8}
+		switch n := int64(binary.BigEndian.Uint32(header[:4])); n {
+		case 0: // The box extends to the end of the range
+			b.size = end - b.offset
+		case 1: // The size follows the type as a 64-bit number
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("failed to read video: %w", err)
+			}
+			b.offset += 8
+			b.size = int64(binary.BigEndian.Uint64(header[8:16])) - 16
+		default:
+			b.size = n - 8
+		}
+		// Compared without adding to the offset, which huge sizes would overflow
+		if b.size < 0 || b.size > end-b.offset {
+			return nil, fmt.Errorf("invalid %q box in video", b.typ)
+		}
+		list = append(list, b)
+		offset = b.offset + b.size
+	}
+	return list, nil
+}
+
+// contents reads the first n bytes of a box
+func contents(r io.ReaderAt, b box, n int64) ([]byte, error) {
+	if b.size < n {
+		return nil, fmt.Errorf("invalid %q box in video", b.typ)
+	}
+	data := make([]byte, n)
+	if _, err := r.ReadAt(data, b.offset); err != nil {
+		return nil, fmt.Errorf("failed to read video: %w", err)
+	}
+	return data, nil
+}
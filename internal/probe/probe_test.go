@@ -0,0 +1,203 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mp4Box encodes a box with a 32-bit size
+func mp4Box(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	return append(append(be32(uint32(len(body)+8)), typ...), body...)
+}
+
+// largeBox encodes a box with the 64-bit size form
+func largeBox(typ string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	return append(append(append(be32(1), typ...), be64(uint64(len(body)+16))...), body...)
+}
+
+// openBox encodes a box whose size is 0, extending to the end of its parent
+func openBox(typ string, parts ...[]byte) []byte {
+	return append(append(be32(0), typ...), bytes.Join(parts, nil)...)
+}
+
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func be64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+// Transformation matrices of the track header, in 16.16 and 2.30 fixed point
+var (
+	identity  = matrix(0x10000, 0, 0, 0x10000)
+	rotate90  = matrix(0, 0x10000, 0xffff0000, 0)
+	rotate180 = matrix(0xffff0000, 0, 0, 0xffff0000)
+	rotate270 = matrix(0, 0xffff0000, 0x10000, 0)
+)
+
+func matrix(a, b, c, d uint32) []byte {
+	return bytes.Join([][]byte{be32(a), be32(b), be32(0), be32(c), be32(d), be32(0), be32(0), be32(0), be32(0x40000000)}, nil)
+}
+
+// mvhd returns a movie header of the given version
+func mvhd(version byte, timescale uint32, duration uint64) []byte {
+	if version == 1 {
+		return mp4Box("mvhd", []byte{1, 0, 0, 0}, be64(0), be64(0), be32(timescale), be64(duration), make([]byte, 80))
+	}
+	return mp4Box("mvhd", []byte{0, 0, 0, 0}, be32(0), be32(0), be32(timescale), be32(uint32(duration)), make([]byte, 80))
+}
+
+// tkhd returns a track header of the given version and displayed size
+func tkhd(version byte, m []byte, width, height uint32) []byte {
+	times := [][]byte{be32(0), be32(0), be32(1), be32(0), be32(0)}
+	if version == 1 {
+		times = [][]byte{be64(0), be64(0), be32(1), be32(0), be64(0)}
+	}
+	return mp4Box("tkhd", []byte{version, 0, 0, 3}, bytes.Join(times, nil), make([]byte, 16), m, be32(width<<16), be32(height<<16))
+}
+
+// trak returns a track with the given header and handler type
+func trak(header []byte, handler string) []byte {
+	hdlr := mp4Box("hdlr", be32(0), be32(0), []byte(handler), make([]byte, 13))
+	return mp4Box("trak", header, mp4Box("mdia", mp4Box("mdhd", make([]byte, 24)), hdlr))
+}
+
+var (
+	ftyp  = mp4Box("ftyp", []byte("isom"), be32(0x200), []byte("isomiso2mp41"))
+	mdat  = mp4Box("mdat", make([]byte, 4096))
+	audio = trak(tkhd(0, identity, 0, 0), "soun")
+)
+
+// movie returns a movie box with a 30 second header and the given tracks
+func movie(tracks ...[]byte) []byte {
+	return mp4Box("moov", append([][]byte{mvhd(0, 600, 30*600)}, tracks...)...)
+}
+
+func file(boxes ...[]byte) []byte {
+	return bytes.Join(boxes, nil)
+}
+
+func TestRead(t *testing.T) {
+	portrait := trak(tkhd(0, identity, 1080, 1920), "vide")
+	tests := []struct {
+		name string
+		data []byte
+		want time.Duration
+		w, h int
+	}{
+		{"movie before media", file(ftyp, movie(portrait), mdat), 30 * time.Second, 1080, 1920},
+		{"movie after media", file(ftyp, mdat, movie(portrait)), 30 * time.Second, 1080, 1920},
+		{"audio track first", file(ftyp, movie(audio, portrait), mdat), 30 * time.Second, 1080, 1920},
+		{"version 1 headers", file(ftyp, mp4Box("moov", mvhd(1, 90000, 45*90000), trak(tkhd(1, identity, 1920, 1080), "vide")), mdat), 45 * time.Second, 1920, 1080},
+		{"fractional duration", file(ftyp, mp4Box("moov", mvhd(0, 1000, 59500), portrait)), 59500 * time.Millisecond, 1080, 1920},
+		{"rotated 90 degrees", file(ftyp, movie(trak(tkhd(0, rotate90, 1920, 1080), "vide")), mdat), 30 * time.Second, 1080, 1920},
+		{"rotated 270 degrees", file(ftyp, movie(trak(tkhd(0, rotate270, 1920, 1080), "vide")), mdat), 30 * time.Second, 1080, 1920},
+		{"rotated 180 degrees", file(ftyp, movie(trak(tkhd(0, rotate180, 1920, 1080), "vide")), mdat), 30 * time.Second, 1920, 1080},
+		{"media box to end of file", file(ftyp, movie(portrait), openBox("mdat", make([]byte, 4096))), 30 * time.Second, 1080, 1920},
+		{"movie box to end of file", file(ftyp, mdat, openBox("moov", mvhd(0, 600, 30*600), portrait)), 30 * time.Second, 1080, 1920},
+		{"64-bit media box", file(ftyp, largeBox("mdat", make([]byte, 4096)), movie(portrait)), 30 * time.Second, 1080, 1920},
+		{"64-bit movie box", file(ftyp, largeBox("moov", mvhd(0, 600, 30*600), portrait), mdat), 30 * time.Second, 1080, 1920},
+		{"first video track wins", file(ftyp, movie(portrait, trak(tkhd(0, identity, 640, 480), "vide"))), 30 * time.Second, 1080, 1920},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := read(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if info.Duration != tt.want || info.Width != tt.w || info.Height != tt.h {
+				t.Errorf("got %v %dx%d, want %v %dx%d", info.Duration, info.Width, info.Height, tt.want, tt.w, tt.h)
+			}
+		})
+	}
+}
+
+func TestReadNotVideo(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"shorter than a box header", []byte("ftyp")},
+		{"no movie box", file(ftyp, mdat)},
+		{"audio only", file(ftyp, movie(audio), mdat)},
+		{"no movie header", file(ftyp, mp4Box("moov", trak(tkhd(0, identity, 1080, 1920), "vide")))},
+		{"track without handler", file(ftyp, movie(mp4Box("trak", tkhd(0, identity, 1080, 1920))))},
+		{"zero size picture", file(ftyp, movie(trak(tkhd(0, identity, 0, 0), "vide")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := read(bytes.NewReader(tt.data), int64(len(tt.data))); !errors.Is(err, ErrNotVideo) {
+				t.Errorf("got %v, want ErrNotVideo", err)
+			}
+		})
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	portrait := trak(tkhd(0, identity, 1080, 1920), "vide")
+	oversized := mp4Box("moov", mvhd(0, 600, 30*600), portrait)
+	binary.BigEndian.PutUint32(oversized, uint32(len(oversized)+100))
+	shortTkhd := mp4Box("tkhd", []byte{0, 0, 0, 3}, make([]byte, 40))
+	shortV1Tkhd := mp4Box("tkhd", []byte{1, 0, 0, 3}, make([]byte, 84))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"box larger than the file", file(ftyp, oversized)},
+		{"box size below its header", file(ftyp, be32(4), []byte("free"), movie(portrait))},
+		{"64-bit size below its header", file(ftyp, be32(1), []byte("mdat"), be64(8), movie(portrait))},
+		{"64-bit size that overflows", file(ftyp, be32(1), []byte("mdat"), be64(1<<63-1), movie(portrait))},
+		{"64-bit size past the end", file(ftyp, be32(1), []byte("mdat"), be64(1<<62), movie(portrait))},
+		{"truncated movie header", file(ftyp, mp4Box("moov", mp4Box("mvhd", make([]byte, 12)), portrait))},
+		{"zero timescale", file(ftyp, mp4Box("moov", mvhd(0, 0, 30*600), portrait))},
+		{"truncated track header", file(ftyp, movie(trak(shortTkhd, "vide")))},
+		{"truncated version 1 track header", file(ftyp, movie(trak(shortV1Tkhd, "vide")))},
+		{"truncated handler", file(ftyp, movie(mp4Box("trak", tkhd(0, identity, 1080, 1920), mp4Box("mdia", mp4Box("hdlr", be32(0))))))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if info, err := read(bytes.NewReader(tt.data), int64(len(tt.data))); err == nil {
+				t.Errorf("read returned %+v, want an error", info)
+			}
+		})
+	}
+}
+
+// Cutting a file short anywhere, as an interrupted upload would, must give
+// an error and never a panic
+func TestReadTruncated(t *testing.T) {
+	for _, data := range [][]byte{
+		file(ftyp, mdat, movie(audio, trak(tkhd(0, rotate90, 1920, 1080), "vide"))),
+		file(ftyp, largeBox("moov", mvhd(1, 600, 30*600), trak(tkhd(1, identity, 1080, 1920), "vide"))),
+	} {
+		for n := 0; n < len(data); n++ {
+			if _, err := read(bytes.NewReader(data[:n]), int64(n)); err == nil {
+				t.Errorf("read of the first %d of %d bytes succeeded", n, len(data))
+			}
+		}
+	}
+}
+
+func TestVideo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, file(ftyp, mdat, movie(trak(tkhd(0, rotate90, 1920, 1080), "vide"))), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Video(path)
+	if err != nil {
+		t.Fatalf("Video: %v", err)
+	}
+	if info.Duration != 30*time.Second || info.Width != 1080 || info.Height != 1920 || !info.Vertical() {
+		t.Errorf("Video = %+v, want a 30 second vertical 1080x1920 video", info)
+	}
+
+	if _, err := Video(filepath.Join(t.TempDir(), "missing.mp4")); err == nil {
+		t.Error("Video of a missing file succeeded")
+	}
+}
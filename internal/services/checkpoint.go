@@ -0,0 +1,38 @@
+package services
+
+import "context"
+
+// Checkpoint persists platform-specific state of a running upload, such as
+// a resumable session URL, so the upload can continue where it stopped
+// after an error or a server restart
+type Checkpoint interface {
+	// Load decodes the saved state into v and reports whether there was any
+	Load(v interface{}) (bool, error)
+	// Save replaces the saved state with v
+	Save(v interface{}) error
+	// Clear removes the saved state
+	Clear() error
+}
+
+type checkpointKey struct{}
+
+// WithCheckpoint returns a context that gives platforms access to cp
+func WithCheckpoint(ctx context.Context, cp Checkpoint) context.Context {
+	return context.WithValue(ctx, checkpointKey{}, cp)
+}
+
+// CheckpointFrom returns the Checkpoint attached to ctx. Without one, the
+// returned Checkpoint saves nothing and uploads start from scratch.
+func CheckpointFrom(ctx context.Context) Checkpoint {
+	if cp, ok := ctx.Value(checkpointKey{}).(Checkpoint); ok {
+		return cp
+	}
+	return noCheckpoint{}
+}
+
+// noCheckpoint is used when the caller does not keep upload state
+type noCheckpoint struct{}
+
+func (noCheckpoint) Load(v interface{}) (bool, error) { return false, nil }
+func (noCheckpoint) Save(v interface{}) error         { return nil }
+func (noCheckpoint) Clear() error                     { return nil }
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"uploader/internal/models"
+)
+
+// Platform is a destination a video can be uploaded to
+type Platform interface {
+	// Name returns the identifier used in form values, e.g. "youtube"
+	Name() string
+	// DisplayName returns the human readable platform name, e.g. "YouTube"
+	DisplayName() string
+	// Validate checks the media and metadata before any upload is attempted
+	Validate(media *Media, meta Metadata) error
+	// Upload sends the media to the platform and reports the outcome
+	Upload(ctx context.Context, media *Media, meta Metadata) models.PlatformResult
+}
+
+// Warner is implemented by platforms that point out problems with an
+// upload that do not stop it, such as a video that will not be shown the
+// way it was meant to be
+type Warner interface {
+	// Warnings checks the media and metadata of an upload that passed Validate
+	Warnings(media *Media, meta Metadata) []string
+}
+
+// Media describes the video file being uploaded.
+// The video is staged once on local disk and every platform opens its own
+// reader, so uploads to several platforms can run at the same time.
+type Media struct {
+	Filename    string
+	Size        int64
+	Path        string                 // Location of the staged copy of the video
+	Video       *models.VideoInfo      // Duration and picture size; nil if the file could not be probed
+	Attachments map[string]*Attachment // Extra files keyed by form field, e.g. "youtubeThumbnail"
+}
+
+// Attachment is an extra file submitted with the video, such as a thumbnail
+type Attachment struct {
+	models.Attachment
+	Path string // Location of the staged copy
+}
+
+// Open returns a new reader over the staged attachment; the caller must close it
+func (a *Attachment) Open() (*os.File, error) {
+	file, err := os.Open(a.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open staged %s: %w", a.Filename, err)
+	}
+	return file, nil
+}
+
+// Open returns a new reader over the staged video; the caller must close it
+func (m *Media) Open() (*os.File, error) {
+	file, err := os.Open(m.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open staged video: %w", err)
+	}
+	return file, nil
+}
+
+// Metadata carries the captions and platform-specific form fields of an upload
+type Metadata struct {
+	UserID      string // Owner of the upload, whose platform accounts are used
+	AccountID   string // Connected account to upload to; set per platform upload
+	MainCaption string
+	Values      url.Values
+}
+
+// Value returns the first value of the named form field
+func (m Metadata) Value(key string) string {
+	return m.Values.Get(key)
+}
+
+// NewResult returns an empty result for the given platform
+func NewResult(p Platform) models.PlatformResult {
+	return models.PlatformResult{
+		Platform:    p.Name(),
+		DisplayName: p.DisplayName(),
+		Status:      models.PlatformPending,
+	}
+}
+
+// FailedResult returns a failed result for the given platform with the error message set
+func FailedResult(p Platform, err error) models.PlatformResult {
+	res := NewResult(p)
+	res.Status = models.PlatformFailed
+	res.Error = err.Error()
+	return res
+}
+
+// NewSubStatus returns the outcome of a follow-up step named name that
+// failed with err, or succeeded if err is nil
+func NewSubStatus(name string, err error) models.SubStatus {
+	if err == nil {
+		return models.SubStatus{Name: name, Success: true}
+	}
+	// Google API errors list their details on further lines
+	msg, _, _ := strings.Cut(err.Error(), "\n")
+	if kind := Classify(err); kind == models.ErrorAuth {
+		msg = strings.TrimSuffix(msg, ".") + ". " + kind.Hint()
+	}
+	return models.SubStatus{Name: name, Error: msg}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"uploader/internal/config"
+	"uploader/internal/models"
+)
+
+// Poller waits for work a platform finishes asynchronously, such as
+// processing an uploaded video before it can be published
+type Poller struct {
+	Interval  time.Duration // Wait before each status check
+	Timeout   time.Duration // Give up once this much time has passed
+	MaxErrors int           // Transient check failures in a row that are tolerated
+}
+
+// NewPoller returns a poller with the configured interval and timeout
+func NewPoller(cfg config.PollConfig) Poller {
+	return Poller{Interval: cfg.PollInterval(), Timeout: cfg.PollTimeout(), MaxErrors: 5}
+}
+
+// PollFunc checks the status once. It reports done when the work has
+// finished, and returns an error for terminal failures. Retryable errors
+// (see Retryable) are tolerated up to Poller.MaxErrors times in a row.
+type PollFunc func(ctx context.Context) (done bool, err error)
+
+// Poll calls check every Interval until it reports done, fails, the
+// Timeout passes or ctx is cancelled
+func (p Poller) Poll(ctx context.Context, what string, check PollFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	timer := time.NewTimer(p.Interval)
+	defer timer.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return NewAPIError(models.ErrorTransient, fmt.Errorf("timed out after %v waiting for %s", p.Timeout, what))
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		done, err := check(ctx)
+		switch {
+		case err == nil && done:
+			return nil
+		case err == nil:
+			failures = 0
+		case ctx.Err() != nil:
+			// Reported by the select above
+		case !Retryable(err):
+			return err
+		default:
+			failures++
+			if failures > p.MaxErrors {
+				return fmt.Errorf("%s: giving up after %d failed status checks: %w", what, failures, err)
+			}
+			log.Printf("%s: status check failed (%d in a row): %v", what, failures, err)
+		}
+		timer.Reset(p.Interval)
+	}
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"context"
+
+	"uploader/internal/models"
+)
+
+// ProgressFunc receives progress reports from a running platform upload
+type ProgressFunc func(models.Progress)
+
+type progressKey struct{}
+
+// WithProgress returns a context that delivers progress reports to fn
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ReportProgress sends a progress report to the ProgressFunc attached to ctx, if any
+func ReportProgress(ctx context.Context, p models.Progress) {
+	if fn, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		fn(p)
+	}
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Platform)
+	// order keeps platforms in registration order for stable rendering
+	order []string
+)
+
+// Register makes a platform available for uploads.
+// It panics if a platform with the same name is registered twice.
+func Register(p Platform) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	name := p.Name()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("services: platform %q registered twice", name))
+	}
+	registry[name] = p
+	order = append(order, name)
+}
+
+// Lookup returns the registered platform with the given name
+func Lookup(name string) (Platform, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	p, ok := registry[name]
+	return p, ok
+}
+
+// Platforms returns all registered platforms in registration order
+func Platforms() []Platform {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	platforms := make([]Platform, 0, len(order))
+	for _, name := range order {
+		platforms = append(platforms, registry[name])
+	}
+	return platforms
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"uploader/internal/models"
+
+	"google.golang.org/api/googleapi"
+)
+
+// APIError is a failed platform API call together with its classification
+type APIError struct {
+	Kind       models.ErrorKind
+	StatusCode int           // HTTP status, if the platform answered
+	RetryAfter time.Duration // Wait requested by the platform, if any
+	Err        error
+}
+
+func (e *APIError) Error() string { return e.Err.Error() }
+func (e *APIError) Unwrap() error { return e.Err }
+
+// NewAPIError classifies err as the given kind
+func NewAPIError(kind models.ErrorKind, err error) *APIError {
+	return &APIError{Kind: kind, Err: err}
+}
+
+// ResponseError builds the error for an unsuccessful HTTP response from
+// its status, body and Retry-After header
+func ResponseError(resp *http.Response, body []byte) *APIError {
+	return &APIError{
+		Kind:       classifyResponse(resp.StatusCode, string(body)),
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		Err:        fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body))),
+	}
+}
+
+// CheckResponse returns nil for a 2xx response and a classified error
+// otherwise. The body is consumed on error.
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return ResponseError(resp, body)
+}
+
+// Classify returns the kind of failure err describes, or "" if it is not
+// known, e.g. because the upload was cancelled
+func Classify(err error) models.ErrorKind {
+	var apiErr *APIError
+	var googleErr *googleapi.Error
+	var netErr net.Error
+	switch {
+	case err == nil, errors.Is(err, context.Canceled):
+		return ""
+	case errors.As(err, &apiErr):
+		return apiErr.Kind
+	case errors.As(err, &googleErr):
+		return classifyResponse(googleErr.Code, googleErr.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return models.ErrorTransient
+	}
+	return ""
+}
+
+// classifyResponse classifies an error response by its status and by the
+// error codes the platforms put in the body
+func classifyResponse(status int, body string) models.ErrorKind {
+	switch {
+	case strings.Contains(body, "quotaExceeded") || strings.Contains(body, "spam_risk_too_many_posts"):
+		return models.ErrorQuota
+	case status == http.StatusTooManyRequests || strings.Contains(body, "rateLimitExceeded") ||
+		strings.Contains(body, "rate_limit_exceeded"):
+		return models.ErrorRateLimited
+	case status == http.StatusUnauthorized || strings.Contains(body, "invalidCredentials") ||
+		strings.Contains(body, "access_token_invalid") || strings.Contains(body, "scope_not_authorized") ||
+		strings.Contains(body, `"code":190`):
+		return models.ErrorAuth
+	case status >= 500 || strings.Contains(body, `"is_transient":true`):
+		return models.ErrorTransient
+	case status == http.StatusForbidden && strings.Contains(body, "scope"):
+		return models.ErrorAuth
+	case status >= 400:
+		return models.ErrorValidation
+	}
+	return ""
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// RetryPolicy decides how often and how long to wait before a failed
+// platform API call is tried again
+type RetryPolicy struct {
+	MaxAttempts   int           // Attempts in total, including the first
+	BaseDelay     time.Duration // Upper bound of the first wait; doubles with every attempt
+	MaxDelay      time.Duration // Upper bound of any computed wait
+	MaxRetryAfter time.Duration // Longest Retry-After that is waited out instead of failing
+}
+
+// DefaultRetryPolicy is used for the platform API calls of every service
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:   5,
+	BaseDelay:     time.Second,
+	MaxDelay:      30 * time.Second,
+	MaxRetryAfter: 2 * time.Minute,
+}
+
+// Retryable reports whether err is worth retrying straight away. Quota
+// errors are not, since quotas reset after hours rather than seconds.
+func Retryable(err error) bool {
+	kind := Classify(err)
+	return kind == models.ErrorTransient || kind == models.ErrorRateLimited
+}
+
+// Do calls fn until it succeeds, fails with an error that is not
+// retryable, or the attempts run out. It returns the last error, or why it
+// stopped waiting to retry.
+func (p RetryPolicy) Do(ctx context.Context, what string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !Retryable(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+		if waitErr := p.Wait(ctx, what, attempt, err); waitErr != nil {
+			return waitError(waitErr, err)
+		}
+	}
+}
+
+// waitError reports that a retry was abandoned because Wait failed, e.g.
+// because the upload was cancelled. Both errors stay visible to errors.Is
+// and errors.As, so Classify still sees a cancellation or the platform's error.
+func waitError(waitErr, err error) error {
+	return fmt.Errorf("%w (last error: %w)", waitErr, err)
+}
+
+// Wait sleeps before retry number attempt of a call that failed with err.
+// It uses the platform's Retry-After if there is one, and otherwise
+// exponential backoff with full jitter. It returns an error without
+// waiting if ctx is done or the platform asks for a longer wait than
+// MaxRetryAfter.
+func (p RetryPolicy) Wait(ctx context.Context, what string, attempt int, err error) error {
+	delay := p.backoff(attempt)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > p.MaxRetryAfter {
+			return fmt.Errorf("%s: platform asked to wait %v", what, apiErr.RetryAfter)
+		}
+		delay = apiErr.RetryAfter
+	}
+
+	log.Printf("%s failed (attempt %d of %d, %s), retrying in %v: %v",
+		what, attempt, p.MaxAttempts, Classify(err), delay.Round(time.Millisecond), err)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns a random wait of up to BaseDelay * 2^(attempt-1),
+// capped at MaxDelay
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if attempt < 31 {
+		ceiling = min(p.BaseDelay<<(attempt-1), p.MaxDelay)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling) + 1
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"uploader/internal/models"
+)
+
+// testPolicy retries quickly so tests do not sleep
+var testPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, MaxRetryAfter: time.Second}
+
+func TestRetryPolicyDo(t *testing.T) {
+	calls := 0
+	err := testPolicy.Do(context.Background(), "test", func() error {
+		if calls++; calls < 3 {
+			return NewAPIError(models.ErrorTransient, errors.New("connection reset"))
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("got %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	err = testPolicy.Do(context.Background(), "test", func() error {
+		calls++
+		return NewAPIError(models.ErrorValidation, errors.New("bad title"))
+	})
+	if Classify(err) != models.ErrorValidation || calls != 1 {
+		t.Errorf("got %v after %d calls, want a validation error without retrying", err, calls)
+	}
+
+	calls = 0
+	err = testPolicy.Do(context.Background(), "test", func() error {
+		calls++
+		return NewAPIError(models.ErrorTransient, errors.New("connection reset"))
+	})
+	if Classify(err) != models.ErrorTransient || calls != testPolicy.MaxAttempts {
+		t.Errorf("got %v after %d calls, want a transient error after %d", err, calls, testPolicy.MaxAttempts)
+	}
+}
+
+func TestRetryPolicyDoCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := testPolicy
+	policy.BaseDelay, policy.MaxDelay = time.Hour, time.Hour
+
+	err := policy.Do(ctx, "test", func() error {
+		cancel()
+		return NewAPIError(models.ErrorTransient, errors.New("connection reset"))
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if kind := Classify(err); kind != "" {
+		t.Errorf("cancelled call classified as %q, want none", kind)
+	}
+	if !strings.Contains(err.Error(), "connection reset") {
+		t.Errorf("error %q lost the last failure", err)
+	}
+}
+
+func TestRetryPolicyDoRetryAfterTooLong(t *testing.T) {
+	calls := 0
+	err := testPolicy.Do(context.Background(), "test", func() error {
+		calls++
+		return &APIError{Kind: models.ErrorRateLimited, StatusCode: 429, RetryAfter: time.Hour, Err: errors.New("slow down")}
+	})
+	if calls != 1 {
+		t.Errorf("called %d times, want no retry", calls)
+	}
+	if kind := Classify(err); kind != models.ErrorRateLimited {
+		t.Errorf("classified as %q, want %q", kind, models.ErrorRateLimited)
+	}
+	if !strings.Contains(err.Error(), "asked to wait 1h0m0s") {
+		t.Errorf("error %q does not say how long the platform asked to wait", err)
+	}
+}
@@ -2,111 +2,204 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"log"
+	"net"
 	"net/http"
-	"os"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"uploader/internal/config"
+	"uploader/internal/mediaurl"
 	"uploader/internal/models"
-
-	"golang.org/x/oauth2"
+	"uploader/internal/tokens"
 )
 
-// UploadToInstagram uploads a video to Instagram as a Reel
-func UploadToInstagram(file multipart.File, header *multipart.FileHeader,
-	caption, mainCaption string, result *models.UploadResult) error {
+// instagramAPI is the base URL of the Instagram Graph API
+const instagramAPI = "https://graph.instagram.com/v22.0"
 
-	// Read Instagram token
-	tokenFile, err := os.ReadFile("instagram_token.json")
-	if err != nil {
-		return fmt.Errorf("user not authenticated with Instagram")
+// Instagram publishes videos to an Instagram professional account as Reels.
+// Instagram downloads the video itself, from a signed URL served by this app.
+type Instagram struct {
+	tokens *tokens.Manager
+	urls   *mediaurl.Signer
+	client *http.Client // For Graph API calls, which never carry the video itself
+}
+
+// NewInstagram returns the Instagram platform
+func NewInstagram(tm *tokens.Manager, urls *mediaurl.Signer) *Instagram {
+	return &Instagram{tokens: tm, urls: urls, client: &http.Client{Timeout: 60 * time.Second}}
+}
+
+// Name implements Platform
+func (ig *Instagram) Name() string { return "instagram" }
+
+// DisplayName implements Platform
+func (ig *Instagram) DisplayName() string { return "Instagram" }
+
+// Validate implements Platform
+func (ig *Instagram) Validate(media *Media, meta Metadata) error {
+	if media == nil {
+		return fmt.Errorf("no video file provided")
 	}
 
-	var token oauth2.Token
-	err = json.Unmarshal(tokenFile, &token)
-	if err != nil {
-		return fmt.Errorf("failed to parse Instagram authentication token")
+	// Instagram fetches the video from the base URL, so it must be public
+	base, err := url.Parse(config.Get().BaseURL)
+	if err != nil || isLocalHost(base.Hostname()) {
+		return fmt.Errorf("Instagram needs a public base_url to fetch the video from, not %s", config.Get().BaseURL)
 	}
+	return nil
+}
 
-	// Use main caption if no specific caption provided
-	if caption == "" {
-		caption = mainCaption
+// isLocalHost reports whether host can only be reached from this machine
+func isLocalHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
 	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified())
+}
 
-	// Create a temporary file to store the video
-	tempFile, err := os.CreateTemp("", "instagram_upload_*.mp4")
+// Upload implements Platform
+func (ig *Instagram) Upload(ctx context.Context, media *Media, meta Metadata) models.PlatformResult {
+	res := NewResult(ig)
+	res.IDLabel = "Reel ID"
+
+	reel, err := ig.upload(ctx, media, meta)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %v", err)
+		log.Printf("Instagram upload failed: %v", err)
+		res.Error = err.Error()
+		res.ErrorKind = Classify(err)
+		return res
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	// Copy the uploaded file to the temporary file
-	_, err = io.Copy(tempFile, file)
+	res.Success = true
+	res.ID = reel.ID
+	res.URL = reel.Permalink
+	return res
+}
+
+// upload uploads a video to Instagram as a Reel and returns the published media
+func (ig *Instagram) upload(ctx context.Context, media *Media, meta Metadata) (*models.InstagramMediaResponse, error) {
+	caption := meta.Value("instagramCaption")
+
+	// Read Instagram token
+	token, err := ig.tokens.Token(ctx, meta.UserID, ig.Name(), meta.AccountID)
 	if err != nil {
-		return fmt.Errorf("failed to copy upload to temporary file: %v", err)
+		return nil, NewAPIError(models.ErrorAuth, fmt.Errorf("user not authenticated with Instagram: %v", err))
+	}
+
+	// Use main caption if no specific caption provided
+	if caption == "" {
+		caption = meta.MainCaption
 	}
 
-	// Step 1: Create container for the media
-	containerURL := "https://graph.instagram.com/v22.0/me/media"
-	containerData := map[string]string{
-		"media_type": "REELS",
-		"video_url":  tempFile.Name(),
-		"caption":    caption,
+	// Step 1: Create a container; Instagram downloads the video from the signed URL
+	ReportProgress(ctx, models.Progress{Phase: "creating container"})
+	containerData := map[string]interface{}{
+		"media_type":    "REELS",
+		"video_url":     ig.urls.URL(filepath.Base(media.Path)),
+		"caption":       caption,
+		"share_to_feed": config.Get().Instagram.ShareToFeed,
 	}
 
-	containerJSON, _ := json.Marshal(containerData)
-	req, err := http.NewRequest("POST", containerURL, bytes.NewBuffer(containerJSON))
+	var container models.InstagramMediaResponse
+	err = DefaultRetryPolicy.Do(ctx, "Instagram container creation", func() error {
+		return ig.graph(ctx, token.AccessToken, "POST", "/me/media", containerData, &container)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create container request: %v", err)
+		return nil, fmt.Errorf("failed to create media container: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	// Step 2: Wait for Instagram to fetch and process the video
+	statusPath := "/" + container.ID + "?fields=status_code,status"
+	err = NewPoller(config.Get().Instagram.PollConfig).Poll(ctx, "Instagram processing", func(ctx context.Context) (bool, error) {
+		var status models.InstagramMediaResponse
+		if err := ig.graph(ctx, token.AccessToken, "GET", statusPath, nil, &status); err != nil {
+			return false, err
+		}
+		ReportProgress(ctx, models.Progress{Phase: "processing", Status: status.StatusCode})
+		return containerReady(status)
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create media container: %v", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	var containerResponse models.InstagramMediaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&containerResponse); err != nil {
-		return fmt.Errorf("failed to decode container response: %v", err)
+	// Step 3: Publish the container; a container can only be published
+	// once, so retrying after an unclear failure cannot post twice
+	ReportProgress(ctx, models.Progress{Phase: "publishing"})
+	var published models.InstagramMediaResponse
+	err = DefaultRetryPolicy.Do(ctx, "Instagram publishing", func() error {
+		return ig.graph(ctx, token.AccessToken, "POST", "/me/media_publish", map[string]string{"creation_id": container.ID}, &published)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish reel: %w", err)
 	}
+	log.Printf("Instagram reel published. Media ID: %s", published.ID)
 
-	// Step 2: Poll for status until media is ready
-	mediaID := containerResponse.ID
-	statusURL := fmt.Sprintf("https://graph.instagram.com/v22.0/%s", mediaID)
+	// Step 4: Look up the public link; the reel is live even if this fails
+	if err := ig.graph(ctx, token.AccessToken, "GET", "/"+published.ID+"?fields=permalink", nil, &published); err != nil {
+		log.Printf("Failed to look up Instagram permalink of %s: %v", published.ID, err)
+	}
+	return &published, nil
+}
 
-	for i := 0; i < 30; i++ { // Poll for up to 5 minutes
-		time.Sleep(10 * time.Second)
+// containerReady interprets the status_code of a media container. ERROR
+// and EXPIRED are final, with the reason Instagram gives in status.
+func containerReady(c models.InstagramMediaResponse) (bool, error) {
+	reason := c.Status
+	if reason == "" {
+		reason = "no reason given"
+	}
 
-		req, _ = http.NewRequest("GET", statusURL, nil)
-		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	switch c.StatusCode {
+	case "FINISHED":
+		return true, nil
+	case "ERROR":
+		return false, NewAPIError(models.ErrorValidation, fmt.Errorf("Instagram could not process the video (ERROR): %s", reason))
+	case "EXPIRED":
+		return false, NewAPIError(models.ErrorTransient, fmt.Errorf("Instagram container expired before it was published (EXPIRED): %s", reason))
+	}
+	return false, nil
+}
 
-		resp, err = client.Do(req)
+// graph calls the Instagram Graph API, sending body as JSON if it is not
+// nil and decoding the response into out
+func (ig *Instagram) graph(ctx context.Context, accessToken, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
 		if err != nil {
-			continue
+			return fmt.Errorf("failed to encode request: %v", err)
 		}
+		reader = bytes.NewReader(data)
+	}
 
-		var statusResponse models.InstagramMediaResponse
-		if err := json.NewDecoder(resp.Body).Decode(&statusResponse); err != nil {
-			resp.Body.Close()
-			continue
-		}
-		resp.Body.Close()
+	req, err := http.NewRequestWithContext(ctx, method, instagramAPI+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
-		if statusResponse.Status == "FINISHED" {
-			result.Instagram.Success = true
-			result.Instagram.ReelID = mediaID
-			return nil
-		}
+	resp, err := ig.client.Do(req)
+	if err != nil {
+		return NewAPIError(models.ErrorTransient, err)
 	}
+	defer resp.Body.Close()
 
-	return fmt.Errorf("timeout waiting for Instagram upload to complete")
+	if err := CheckResponse(resp); err != nil {
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
 }
@@ -7,121 +7,158 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"mime/multipart"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"uploader/internal/config"
 	"uploader/internal/models"
+	"uploader/internal/tokens"
 )
 
-// UploadToTikTok uploads a video to TikTok using the v2 API Direct Post method
-func UploadToTikTok(ctx context.Context, file multipart.File, header *multipart.FileHeader,
-	caption, mainCaption string, result *models.UploadResult) error {
+// TikTok posts videos to TikTok using the v2 API Direct Post method
+type TikTok struct {
+	tokens *tokens.Manager
+}
+
+// NewTikTok returns the TikTok platform
+func NewTikTok(tm *tokens.Manager) *TikTok {
+	return &TikTok{tokens: tm}
+}
+
+// Name implements Platform
+func (t *TikTok) Name() string { return "tiktok" }
+
+// DisplayName implements Platform
+func (t *TikTok) DisplayName() string { return "TikTok" }
+
+// Validate implements Platform
+func (t *TikTok) Validate(media *Media, meta Metadata) error {
+	if media == nil {
+		return fmt.Errorf("no video file provided")
+	}
+	if _, err := planTikTokChunks(media.Size); err != nil {
+		return err
+	}
+	return tiktokOptionsFrom(meta).validate()
+}
+
+// Upload implements Platform
+func (t *TikTok) Upload(ctx context.Context, media *Media, meta Metadata) models.PlatformResult {
+	res := NewResult(t)
+	res.IDLabel = "Publish ID"
+
+	if err := t.upload(ctx, media, meta, &res); err != nil {
+		log.Printf("TikTok upload failed: %v", err)
+		// Keep the user-facing message if one was set, otherwise surface the error
+		if res.Error == "" {
+			res.Error = err.Error()
+		}
+		res.ErrorKind = Classify(err)
+	}
+	return res
+}
 
+// upload uploads the video in chunks, recording user-facing errors in res
+func (t *TikTok) upload(ctx context.Context, media *Media, meta Metadata, res *models.PlatformResult) error {
 	// If no specific caption is provided, use the main caption
+	caption := meta.Value("tiktokCaption")
 	if caption == "" {
-		caption = mainCaption
+		caption = meta.MainCaption
 	}
 
 	// --- 1. Read authentication token ---
-	tokenFile, err := os.ReadFile("tiktok_token.json")
+	token, err := t.tokens.Token(ctx, meta.UserID, t.Name(), meta.AccountID)
 	if err != nil {
-		result.TikTok.Success = false
-		result.TikTok.Error = "User not authenticated with TikTok"
-		return fmt.Errorf("user not authenticated with TikTok: %v", err)
+		res.Error = "User not authenticated with TikTok"
+		return NewAPIError(models.ErrorAuth, fmt.Errorf("user not authenticated with TikTok: %v", err))
 	}
 
-	var tokenResponse models.TikTokTokenResponse
-	err = json.Unmarshal(tokenFile, &tokenResponse)
+	// --- 2. Plan the chunks ---
+	fileSize := media.Size
+	plan, err := planTikTokChunks(fileSize)
 	if err != nil {
-		result.TikTok.Success = false
-		result.TikTok.Error = "Failed to parse TikTok authentication token"
-		return fmt.Errorf("failed to parse TikTok authentication token: %v", err)
+		res.Error = err.Error()
+		return NewAPIError(models.ErrorValidation, err)
 	}
 
-	// --- 2. Calculate file size and chunk information ---
-	fileSize := header.Size
-	if fileSize == 0 {
-		result.TikTok.Success = false
-		result.TikTok.Error = "Cannot upload empty file"
-		return fmt.Errorf("cannot upload empty file")
-	}
-
-	// Try using integer division only with no remainder handling
-	// In TikTok's example: 50,000,123 ÷ 10,000,000 = 5.0000123, but they use 5 chunks
-	const exactChunkSize = 10 * 1000 * 1000 // exactly 10,000,000 bytes as in their docs
-
-	// Calculate total chunks using ONLY integer division (truncate decimal part)
-	totalChunks := int(fileSize / exactChunkSize)
+	log.Printf("TikTok upload details: File size = %d bytes, Chunk size = %d bytes, Total chunks = %d",
+		fileSize, plan.Size, plan.Count)
 
-	// Ensure we have at least 1 chunk
-	if totalChunks < 1 {
-		totalChunks = 1
+	// --- 3. Check the post options against what the creator may use now ---
+	creator, err := t.CreatorInfo(ctx, meta.UserID, meta.AccountID)
+	if err != nil {
+		res.Error = "Failed to check what this TikTok account may post"
+		return err
+	}
+	opts := tiktokOptionsFrom(meta)
+	if err := opts.validate(); err != nil {
+		res.Error = err.Error()
+		return NewAPIError(models.ErrorValidation, err)
+	}
+	postInfo, err := opts.postInfo(caption, creator)
+	if err != nil {
+		res.Error = err.Error()
+		return NewAPIError(models.ErrorValidation, err)
 	}
 
-	log.Printf("TikTok upload details: File size = %d bytes, Chunk size = %d bytes, Total chunks = %d",
-		fileSize, exactChunkSize, totalChunks)
-
-	// --- 3. Initialize upload (get upload URL) ---
+	// --- 4. Initialize upload (get upload URL) ---
 	client := &http.Client{Timeout: 60 * time.Second}
 	initEndpoint := "https://open.tiktokapis.com/v2/post/publish/video/init/"
 
 	initRequest := map[string]interface{}{
-		"post_info": map[string]interface{}{
-			"title":           caption,
-			"privacy_level":   "SELF_ONLY", // Start with private visibility
-			"disable_duet":    false,
-			"disable_comment": false,
-			"disable_stitch":  false,
-		},
+		"post_info": postInfo,
 		"source_info": map[string]interface{}{
 			"source":            "FILE_UPLOAD",
 			"video_size":        fileSize,
-			"chunk_size":        exactChunkSize,
-			"total_chunk_count": totalChunks,
+			"chunk_size":        plan.Size,
+			"total_chunk_count": plan.Count,
 		},
 	}
 
 	initJSON, err := json.Marshal(initRequest)
 	if err != nil {
-		result.TikTok.Success = false
-		result.TikTok.Error = "Failed to create initialization request"
+		res.Error = "Failed to create initialization request"
 		return fmt.Errorf("failed to create initialization request: %v", err)
 	}
 
 	log.Printf("Sending TikTok init request: %s", string(initJSON))
 
-	req, err := http.NewRequestWithContext(ctx, "POST", initEndpoint, bytes.NewBuffer(initJSON))
-	if err != nil {
-		result.TikTok.Success = false
-		result.TikTok.Error = "Failed to create initialization request"
-		return fmt.Errorf("failed to create initialization request: %v", err)
-	}
+	var body []byte
+	err = DefaultRetryPolicy.Do(ctx, "TikTok upload initialization", func() error {
+		req, err := http.NewRequestWithContext(ctx, "POST", initEndpoint, bytes.NewReader(initJSON))
+		if err != nil {
+			res.Error = "Failed to create initialization request"
+			return fmt.Errorf("failed to create initialization request: %v", err)
+		}
 
-	req.Header.Set("Authorization", "Bearer "+tokenResponse.AccessToken)
-	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 
-	resp, err := client.Do(req)
-	if err != nil {
-		result.TikTok.Success = false
-		result.TikTok.Error = "Failed to connect to TikTok API"
-		return fmt.Errorf("failed to connect to TikTok API: %v", err)
-	}
-	defer resp.Body.Close()
+		resp, err := client.Do(req)
+		if err != nil {
+			res.Error = "Failed to connect to TikTok API"
+			return NewAPIError(models.ErrorTransient, fmt.Errorf("failed to connect to TikTok API: %v", err))
+		}
+		defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		result.TikTok.Success = false
-		result.TikTok.Error = "Failed to read API response"
-		return fmt.Errorf("failed to read API response: %v", err)
-	}
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			res.Error = "Failed to read API response"
+			return NewAPIError(models.ErrorTransient, fmt.Errorf("failed to read API response: %v", err))
+		}
 
-	if resp.StatusCode != http.StatusOK {
-		result.TikTok.Success = false
-		result.TikTok.Error = fmt.Sprintf("Failed to initialize upload (HTTP %d)", resp.StatusCode)
-		log.Printf("TikTok init failed with status %d: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("failed to initialize upload: %s", string(body))
+		if resp.StatusCode != http.StatusOK {
+			res.Error = fmt.Sprintf("Failed to initialize upload (HTTP %d)", resp.StatusCode)
+			log.Printf("TikTok init failed with status %d: %s", resp.StatusCode, string(body))
+			return fmt.Errorf("failed to initialize upload: %w", ResponseError(resp, body))
+		}
+		res.Error = ""
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Parse the initialization response
@@ -139,140 +176,219 @@ func UploadToTikTok(ctx context.Context, file multipart.File, header *multipart.
 
 	err = json.Unmarshal(body, &initResponse)
 	if err != nil {
-		result.TikTok.Success = false
-		result.TikTok.Error = "Failed to parse initialization response"
+		res.Error = "Failed to parse initialization response"
 		return fmt.Errorf("failed to parse initialization response: %v", err)
 	}
 
 	// Check for API errors
 	if initResponse.Error.Code != "ok" && initResponse.Error.Code != "" {
-		result.TikTok.Success = false
-		result.TikTok.Error = fmt.Sprintf("TikTok API error: %s", initResponse.Error.Message)
-		return fmt.Errorf("TikTok API error: %s (code: %s, log: %s)",
-			initResponse.Error.Message, initResponse.Error.Code, initResponse.Error.LogID)
+		res.Error = fmt.Sprintf("TikTok API error: %s", initResponse.Error.Message)
+		return NewAPIError(classifyResponse(http.StatusBadRequest, initResponse.Error.Code), fmt.Errorf("TikTok API error: %s (code: %s, log: %s)",
+			initResponse.Error.Message, initResponse.Error.Code, initResponse.Error.LogID))
 	}
 
 	// Validate response data
 	if initResponse.Data.UploadURL == "" {
-		result.TikTok.Success = false
-		result.TikTok.Error = "No upload URL received from TikTok API"
+		res.Error = "No upload URL received from TikTok API"
 		return fmt.Errorf("no upload URL received from TikTok API")
 	}
 
 	if initResponse.Data.PublishID == "" {
-		result.TikTok.Success = false
-		result.TikTok.Error = "No publish ID received from TikTok API"
+		res.Error = "No publish ID received from TikTok API"
 		return fmt.Errorf("no publish ID received from TikTok API")
 	}
 
-	// --- 4. Upload the video file in chunks ---
+	// --- 5. Upload the video file in chunks ---
 	uploadURL := initResponse.Data.UploadURL
 	publishID := initResponse.Data.PublishID
 
 	log.Printf("TikTok initialization successful. PublishID: %s", publishID)
 
-	// Reset file position to the beginning
-	if _, err := file.Seek(0, 0); err != nil {
-		result.TikTok.Success = false
-		result.TikTok.Error = "Failed to prepare file for upload"
-		return fmt.Errorf("failed to prepare file for upload: %v", err)
+	// Open a reader over the staged video
+	file, err := media.Open()
+	if err != nil {
+		res.Error = "Failed to prepare file for upload"
+		return err
 	}
+	defer file.Close()
 
 	// Prepare for chunked upload
 	uploadClient := &http.Client{Timeout: 15 * time.Minute}
 
-	// Upload each chunk
-	for i := 0; i < totalChunks; i++ {
+	ReportProgress(ctx, models.Progress{Phase: "uploading", TotalBytes: fileSize, TotalChunks: plan.Count})
+
+	// Upload each chunk, streaming it from the file
+	for i := 0; i < plan.Count; i++ {
 		// Check if request is cancelled
 		if ctx.Err() != nil {
-			result.TikTok.Success = false
-			result.TikTok.Error = "Upload cancelled"
+			res.Error = "Upload cancelled"
 			return fmt.Errorf("upload cancelled: %v", ctx.Err())
 		}
 
-		// Calculate chunk bounds
-		startByte := int64(i) * exactChunkSize
-		endByte := startByte + exactChunkSize - 1
+		startByte, n := plan.Chunk(i)
+		endByte := startByte + n - 1
+
+		// Send the chunk, retrying transient failures from the start of it
+		isLastChunk := (i == plan.Count-1)
+		err = DefaultRetryPolicy.Do(ctx, fmt.Sprintf("TikTok chunk %d/%d", i+1, plan.Count), func() error {
+			chunk := &ProgressReader{
+				Reader:  io.NewSectionReader(file, startByte, n),
+				Total:   fileSize,
+				Current: startByte,
+				OnProgress: func(current, total int64) {
+					ReportProgress(ctx, models.Progress{
+						Phase:       "uploading",
+						BytesSent:   current,
+						TotalBytes:  total,
+						Chunk:       i + 1,
+						TotalChunks: plan.Count,
+					})
+				},
+			}
 
-		// Adjust the last chunk size if needed
-		if endByte >= fileSize {
-			endByte = fileSize - 1
-		}
-		currentChunkSize := endByte - startByte + 1
-
-		// For the last chunk, make sure we read the exact remaining bytes
-		var bytesToRead int64
-		if i == totalChunks-1 {
-			bytesToRead = fileSize - startByte
-		} else {
-			bytesToRead = currentChunkSize
-		}
+			// Create the upload request for this chunk
+			uploadReq, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, chunk)
+			if err != nil {
+				res.Error = fmt.Sprintf("Failed to create upload request for chunk %d", i+1)
+				return fmt.Errorf("failed to create upload request for chunk %d: %v", i+1, err)
+			}
 
-		// Read the chunk data
-		chunkData := make([]byte, bytesToRead)
-		n, err := io.ReadFull(file, chunkData)
-		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-			result.TikTok.Success = false
-			result.TikTok.Error = fmt.Sprintf("Failed to read chunk %d", i+1)
-			return fmt.Errorf("failed to read chunk %d: %v", i+1, err)
-		}
+			// Set headers for chunked upload
+			uploadReq.ContentLength = n
+			uploadReq.Header.Set("Content-Type", "video/mp4")
+			uploadReq.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", startByte, endByte, fileSize))
 
-		// Prepare the chunk data
+			log.Printf("Uploading chunk %d/%d: bytes %d-%d/%d", i+1, plan.Count, startByte, endByte, fileSize)
 
-		// Create the upload request for this chunk
-		uploadReq, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, bytes.NewReader(chunkData[:n]))
+			uploadResp, err := uploadClient.Do(uploadReq)
+			if err != nil {
+				res.Error = fmt.Sprintf("Failed to upload chunk %d", i+1)
+				return NewAPIError(models.ErrorTransient, fmt.Errorf("failed to upload chunk %d: %v", i+1, err))
+			}
+
+			// Read response body
+			uploadRespBody, _ := io.ReadAll(uploadResp.Body)
+			uploadResp.Body.Close()
+
+			// Check response status
+			if isLastChunk {
+				// Final chunk should return 200 OK or 201 Created
+				if uploadResp.StatusCode != http.StatusOK && uploadResp.StatusCode != http.StatusCreated {
+					res.Error = fmt.Sprintf("Failed to upload final chunk (HTTP %d)", uploadResp.StatusCode)
+					return fmt.Errorf("failed to upload final chunk: %w", ResponseError(uploadResp, uploadRespBody))
+				}
+			} else {
+				// Intermediate chunks should return 206 Partial Content
+				if uploadResp.StatusCode != http.StatusPartialContent {
+					res.Error = fmt.Sprintf("Failed to upload chunk %d (HTTP %d)", i+1, uploadResp.StatusCode)
+					return fmt.Errorf("failed to upload chunk %d: expected status 206: %w", i+1, ResponseError(uploadResp, uploadRespBody))
+				}
+			}
+			res.Error = ""
+			return nil
+		})
 		if err != nil {
-			result.TikTok.Success = false
-			result.TikTok.Error = fmt.Sprintf("Failed to create upload request for chunk %d", i+1)
-			return fmt.Errorf("failed to create upload request for chunk %d: %v", i+1, err)
+			return err
 		}
 
-		// Set headers for chunked upload
-		uploadReq.Header.Set("Content-Type", "video/mp4")
-		uploadReq.Header.Set("Content-Length", fmt.Sprintf("%d", n))
-		uploadReq.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", startByte, startByte+int64(n)-1, fileSize))
-
-		log.Printf("Uploading chunk %d/%d: bytes %d-%d/%d", i+1, totalChunks,
-			startByte, startByte+int64(n)-1, fileSize)
+		log.Printf("Successfully uploaded chunk %d/%d", i+1, plan.Count)
+	}
 
-		// Send the chunk
-		uploadResp, err := uploadClient.Do(uploadReq)
+	// --- 6. Wait until TikTok has processed and published the post ---
+	log.Printf("TikTok upload completed. Waiting for PublishID %s to be published", publishID)
+	var status tiktokPublishStatus
+	err = NewPoller(config.Get().TikTok.PollConfig).Poll(ctx, "TikTok publishing", func(ctx context.Context) (bool, error) {
+		var err error
+		status, err = fetchPublishStatus(ctx, client, token.AccessToken, publishID)
 		if err != nil {
-			result.TikTok.Success = false
-			result.TikTok.Error = fmt.Sprintf("Failed to upload chunk %d", i+1)
-			return fmt.Errorf("failed to upload chunk %d: %v", i+1, err)
+			return false, err
 		}
+		ReportProgress(ctx, models.Progress{Phase: "publishing", Status: status.Status})
+		return status.done()
+	})
+	if err != nil {
+		return err
+	}
 
-		// Read response body
-		uploadRespBody, _ := io.ReadAll(uploadResp.Body)
-		uploadResp.Body.Close()
-
-		// Check response status
-		isLastChunk := (i == totalChunks-1)
-		if isLastChunk {
-			// Final chunk should return 200 OK or 201 Created
-			if uploadResp.StatusCode != http.StatusOK && uploadResp.StatusCode != http.StatusCreated {
-				result.TikTok.Success = false
-				result.TikTok.Error = fmt.Sprintf("Failed to upload final chunk (HTTP %d)", uploadResp.StatusCode)
-				return fmt.Errorf("failed to upload final chunk: status %d, response: %s",
-					uploadResp.StatusCode, string(uploadRespBody))
-			}
-		} else {
-			// Intermediate chunks should return 206 Partial Content
-			if uploadResp.StatusCode != http.StatusPartialContent {
-				result.TikTok.Success = false
-				result.TikTok.Error = fmt.Sprintf("Failed to upload chunk %d (HTTP %d)", i+1, uploadResp.StatusCode)
-				return fmt.Errorf("failed to upload chunk %d: expected status 206, got %d, response: %s",
-					i+1, uploadResp.StatusCode, string(uploadRespBody))
-			}
-		}
+	// --- 7. Success! ---
+	log.Printf("TikTok post published successfully. PublishID: %s, status: %s", publishID, status.Status)
+	res.Success = true
+	res.ID = publishID
+	if len(status.PostIDs) > 0 {
+		// Only public posts get a post ID
+		res.ID = strconv.FormatInt(status.PostIDs[0], 10)
+		res.IDLabel = "Post ID"
+	}
+	return nil
+}
+
+// tiktokPublishStatus is the data of a publish status response
+type tiktokPublishStatus struct {
+	Status     string  `json:"status"` // e.g. PROCESSING_UPLOAD, PUBLISH_COMPLETE or FAILED
+	FailReason string  `json:"fail_reason"`
+	PostIDs    []int64 `json:"publicaly_available_post_id"` // Spelled as in the API
+}
+
+// done reports whether the post has been published, or returns an error
+// if TikTok gave up on it
+func (s tiktokPublishStatus) done() (bool, error) {
+	switch s.Status {
+	case "PUBLISH_COMPLETE", "SEND_TO_USER_INBOX":
+		return true, nil
+	case "FAILED":
+		return false, NewAPIError(tiktokFailKind(s.FailReason), fmt.Errorf("TikTok could not publish the video: %s", s.FailReason))
+	}
+	return false, nil
+}
 
-		log.Printf("Successfully uploaded chunk %d/%d", i+1, totalChunks)
+// tiktokFailKind classifies the fail_reason of a failed post
+func tiktokFailKind(reason string) models.ErrorKind {
+	switch {
+	case reason == "internal" || reason == "video_pull_failed" || reason == "photo_pull_failed":
+		return models.ErrorTransient
+	case reason == "auth_removed":
+		return models.ErrorAuth
+	case reason == "spam_risk_too_many_posts" || reason == "spam_risk_user_banned_from_posting":
+		return models.ErrorQuota
+	case strings.HasSuffix(reason, "_check_failed"):
+		return models.ErrorValidation // file format, duration, frame rate or picture size
 	}
+	return ""
+}
 
-	// --- 5. Success! ---
-	log.Printf("TikTok upload completed successfully. PublishID: %s", publishID)
-	result.TikTok.Success = true
-	result.TikTok.PostID = publishID
-	return nil
+// fetchPublishStatus asks TikTok how far publishing the post has got
+func fetchPublishStatus(ctx context.Context, client *http.Client, accessToken, publishID string) (tiktokPublishStatus, error) {
+	body, _ := json.Marshal(map[string]string{"publish_id": publishID})
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://open.tiktokapis.com/v2/post/publish/status/fetch/", bytes.NewReader(body))
+	if err != nil {
+		return tiktokPublishStatus{}, fmt.Errorf("failed to create status request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return tiktokPublishStatus{}, NewAPIError(models.ErrorTransient, fmt.Errorf("failed to fetch publish status: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if err := CheckResponse(resp); err != nil {
+		return tiktokPublishStatus{}, fmt.Errorf("failed to fetch publish status: %w", err)
+	}
+
+	var statusResponse struct {
+		Data  tiktokPublishStatus `json:"data"`
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&statusResponse); err != nil {
+		return tiktokPublishStatus{}, fmt.Errorf("failed to parse publish status: %v", err)
+	}
+	if code := statusResponse.Error.Code; code != "" && code != "ok" {
+		return tiktokPublishStatus{}, NewAPIError(classifyResponse(http.StatusBadRequest, code),
+			fmt.Errorf("TikTok API error: %s (code: %s)", statusResponse.Error.Message, code))
+	}
+	return statusResponse.Data, nil
 }
@@ -2,132 +2,192 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"mime/multipart"
-	"os"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"uploader/internal/config"
 	"uploader/internal/models"
+	"uploader/internal/tokens"
 
-	"golang.org/x/oauth2"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
-// UploadToYoutube uploads a video to YouTube
-func UploadToYoutube(file multipart.File, header *multipart.FileHeader,
-	title, description, mainCaption string, result *models.UploadResult) error {
+// YouTube uploads videos to the authenticated user's YouTube channel
+type YouTube struct {
+	tokens *tokens.Manager
 
-	log.Printf("Starting YouTube upload process for file: %s, size: %d bytes", header.Filename, header.Size)
+	mu                sync.Mutex
+	categories        []models.YouTubeCategory // Cached result of Categories
+	categoriesFetched time.Time
+}
+
+// NewYouTube returns the YouTube platform
+func NewYouTube(tm *tokens.Manager) *YouTube {
+	return &YouTube{tokens: tm}
+}
 
+// Name implements Platform
+func (y *YouTube) Name() string { return "youtube" }
+
+// DisplayName implements Platform
+func (y *YouTube) DisplayName() string { return "YouTube" }
+
+// Validate implements Platform
+func (y *YouTube) Validate(media *Media, meta Metadata) error {
 	// Check if file is provided
-	if file == nil || header == nil {
-		log.Printf("YouTube upload failed: no file provided")
+	if media == nil {
 		return fmt.Errorf("no video file provided")
 	}
 
 	// Check file size (YouTube has a limit of 256GB)
-	if header.Size > 256*1024*1024*1024 {
-		log.Printf("YouTube upload failed: file size %d bytes exceeds YouTube's maximum limit of 256GB", header.Size)
+	if media.Size > 256*1024*1024*1024 {
 		return fmt.Errorf("file size exceeds YouTube's maximum limit of 256GB")
 	}
 
 	// Check file type
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".mp4") {
-		log.Printf("YouTube upload failed: file type %s is not supported", header.Filename)
+	if !strings.HasSuffix(strings.ToLower(media.Filename), ".mp4") {
 		return fmt.Errorf("only MP4 files are supported")
 	}
 
-	log.Printf("Reading YouTube authentication token")
-	tokenFile, err := os.ReadFile("youtube_token.json")
-	if err != nil {
-		log.Printf("YouTube upload failed: authentication token file not found: %v", err)
-		return fmt.Errorf("YouTube authentication required: %v", err)
+	// Validate title
+	if meta.Value("youtubeTitle") == "" {
+		return fmt.Errorf("YouTube title is required")
 	}
 
-	var token oauth2.Token
-	err = json.Unmarshal(tokenFile, &token)
-	if err != nil {
-		log.Printf("YouTube upload failed: invalid authentication token format: %v", err)
-		return fmt.Errorf("invalid YouTube authentication token: %v", err)
+	if thumb := media.Attachments[youtubeThumbnailField]; thumb != nil {
+		if err := validateThumbnail(thumb); err != nil {
+			return err
+		}
+	}
+	for _, caption := range captionsFrom(media, meta) {
+		if err := caption.validate(); err != nil {
+			return err
+		}
 	}
 
-	// Check if token is expired
-	if time.Now().After(token.Expiry) {
-		log.Printf("YouTube upload failed: authentication token expired at %v", token.Expiry)
-		return fmt.Errorf("YouTube authentication token has expired, please login again")
+	opts, err := youtubeOptionsFrom(meta)
+	if err != nil {
+		return err
 	}
+	return opts.validate()
+}
 
-	log.Printf("Initializing YouTube service with OAuth client")
-	cfg := config.Get()
-	client := cfg.YouTubeOAuthConfig.Client(context.Background(), &token)
-	service, err := youtube.NewService(context.Background(), option.WithHTTPClient(client))
+// Upload implements Platform
+func (y *YouTube) Upload(ctx context.Context, media *Media, meta Metadata) models.PlatformResult {
+	res := NewResult(y)
+	res.IDLabel = "Video ID"
+
+	videoID, err := y.upload(ctx, media, meta, &res)
 	if err != nil {
-		log.Printf("YouTube upload failed: service initialization error: %v", err)
-		return fmt.Errorf("failed to initialize YouTube service: %v", err)
+		log.Printf("YouTube upload failed: %v", err)
+		res.Error = err.Error()
+		res.ErrorKind = Classify(err)
+		return res
 	}
 
-	// Validate title
-	if title == "" {
-		log.Printf("YouTube upload failed: title is required")
-		return fmt.Errorf("video title is required")
+	res.Success = true
+	res.ID = videoID
+	res.URL = "https://www.youtube.com/watch?v=" + videoID
+	return res
+}
+
+// upload performs the YouTube upload and returns the new video ID. The
+// outcome of follow-up steps such as setting the thumbnail is added to res.
+func (y *YouTube) upload(ctx context.Context, media *Media, meta Metadata, res *models.PlatformResult) (string, error) {
+	log.Printf("Starting YouTube upload process for file: %s, size: %d bytes", media.Filename, media.Size)
+
+	title := meta.Value("youtubeTitle")
+	description := meta.Value("youtubeDescription")
+
+	log.Printf("Reading YouTube authentication token")
+	token, err := y.tokens.Token(ctx, meta.UserID, y.Name(), meta.AccountID)
+	if err != nil {
+		log.Printf("YouTube upload failed: %v", err)
+		return "", NewAPIError(models.ErrorAuth, fmt.Errorf("YouTube authentication required: %v", err))
 	}
 
+	cfg := config.Get()
+	client := cfg.YouTubeOAuthConfig.Client(ctx, tokens.OAuth2(token))
+
 	// Use main caption if no specific description provided
 	if description == "" {
-		description = mainCaption
+		description = meta.MainCaption
 		log.Printf("Using main caption as description")
 	}
-
-	log.Printf("Preparing video metadata: title='%s', description length=%d", title, len(description))
-	upload := &youtube.Video{
-		Snippet: &youtube.VideoSnippet{
-			Title:       title,
-			Description: description,
-			CategoryId:  "22", // People & Blogs category
-		},
-		Status: &youtube.VideoStatus{PrivacyStatus: "private"},
+	if meta.Value(youtubeShortField) != "" {
+		title, description = withShortsTag(title, description)
 	}
 
-	log.Printf("Starting YouTube API upload call")
-	call := service.Videos.Insert([]string{"snippet", "status"}, upload)
-
-	// Create a progress reader to track upload progress
-	progressReader := &ProgressReader{
-		Reader: file,
-		Total:  header.Size,
-		OnProgress: func(current, total int64) {
-			percent := float64(current) / float64(total) * 100
-			log.Printf("YouTube upload progress: %.2f%% (%d/%d bytes)", percent, current, total)
-		},
+	opts, err := youtubeOptionsFrom(meta)
+	if err == nil {
+		err = opts.validate()
+	}
+	if err != nil {
+		return "", NewAPIError(models.ErrorValidation, err)
 	}
 
-	response, err := call.Media(progressReader).Do()
+	log.Printf("Preparing video metadata: title='%s', description length=%d, privacy=%s", title, len(description), opts.Privacy)
+	upload := opts.video(title, description)
+
+	log.Printf("Starting YouTube resumable upload")
+	response, err := resumableUpload(ctx, client, media, upload)
 	if err != nil {
 		// Check for specific YouTube API errors
-		if strings.Contains(err.Error(), "quotaExceeded") {
+		switch kind := Classify(err); {
+		case kind == models.ErrorQuota:
 			log.Printf("YouTube upload failed: API quota exceeded")
-			return fmt.Errorf("YouTube API quota exceeded, please try again later")
-		} else if strings.Contains(err.Error(), "invalidCredentials") {
+			return "", NewAPIError(kind, fmt.Errorf("YouTube API quota exceeded, please try again later"))
+		case kind == models.ErrorAuth:
 			log.Printf("YouTube upload failed: invalid credentials")
-			return fmt.Errorf("YouTube authentication failed, please login again")
-		} else if strings.Contains(err.Error(), "invalidContent") {
+			return "", NewAPIError(kind, fmt.Errorf("YouTube authentication failed, please login again"))
+		case strings.Contains(err.Error(), "invalidContent"):
 			log.Printf("YouTube upload failed: invalid content: %v", err)
-			return fmt.Errorf("invalid video content: %v", err)
+			return "", fmt.Errorf("invalid video content: %w", err)
 		}
 		log.Printf("YouTube upload failed with error: %v", err)
-		return fmt.Errorf("failed to upload to YouTube: %v", err)
+		return "", fmt.Errorf("failed to upload to YouTube: %w", err)
 	}
 
 	log.Printf("YouTube upload completed successfully. Video ID: %s", response.Id)
-	result.YouTube.Success = true
-	result.YouTube.VideoID = response.Id
-	return nil
+
+	// The video is up; failed follow-up steps are reported but do not
+	// fail the upload
+	thumb := media.Attachments[youtubeThumbnailField]
+	playlists := chosenPlaylists(meta, meta.AccountID)
+	captions := captionsFrom(media, meta)
+	if thumb == nil && len(playlists) == 0 && len(captions) == 0 {
+		return response.Id, nil
+	}
+	service, err := newYouTubeService(ctx, client)
+	if err != nil {
+		res.SubStatuses = append(res.SubStatuses, NewSubStatus("Follow-up steps", err))
+		return response.Id, nil
+	}
+	if thumb != nil {
+		res.SubStatuses = append(res.SubStatuses, setThumbnail(ctx, service, response.Id, thumb))
+	}
+	if len(playlists) > 0 {
+		res.SubStatuses = append(res.SubStatuses, addToPlaylists(ctx, service, response.Id, playlists)...)
+	}
+	if len(captions) > 0 {
+		res.SubStatuses = append(res.SubStatuses, insertCaptions(ctx, service, response.Id, captions)...)
+	}
+	return response.Id, nil
+}
+
+// newYouTubeService returns a YouTube API client that authenticates with client
+func newYouTubeService(ctx context.Context, client *http.Client) (*youtube.Service, error) {
+	service, err := youtube.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create YouTube client: %v", err)
+	}
+	return service, nil
 }
 
 // ProgressReader is a wrapper around io.Reader that tracks progress
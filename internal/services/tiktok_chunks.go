@@ -0,0 +1,56 @@
+package services
+
+import "fmt"
+
+// Limits of TikTok's media transfer protocol for FILE_UPLOAD
+const (
+	tiktokMinChunkSize      = 5 << 20   // Smaller videos are sent as a single chunk
+	tiktokMaxChunkSize      = 64 << 20  // Largest chunk_size that may be declared
+	tiktokMaxFinalChunkSize = 128 << 20 // The final chunk takes the remainder and may grow to this
+	tiktokMaxChunks         = 1000
+
+	// tiktokPreferredChunkSize keeps progress updates frequent and the
+	// amount sent again after a failed chunk small
+	tiktokPreferredChunkSize = 10 << 20
+)
+
+// tiktokChunkPlan is how a video is split for upload. Every chunk is Size
+// bytes except the final one, which also carries the remainder.
+type tiktokChunkPlan struct {
+	VideoSize int64
+	Size      int64 // chunk_size declared to TikTok
+	Count     int   // total_chunk_count declared to TikTok
+}
+
+// planTikTokChunks splits a video of the given size into chunks TikTok
+// accepts. TikTok counts chunks by rounding down, so the final chunk is
+// between Size and 2*Size-1 bytes; capping Size at 64 MB keeps it within
+// the 128 MB limit for the final chunk.
+func planTikTokChunks(videoSize int64) (tiktokChunkPlan, error) {
+	switch {
+	case videoSize <= 0:
+		return tiktokChunkPlan{}, fmt.Errorf("cannot upload empty file")
+	case videoSize < tiktokMinChunkSize:
+		return tiktokChunkPlan{VideoSize: videoSize, Size: videoSize, Count: 1}, nil
+	}
+
+	// Grow the chunks when the preferred size would need too many
+	size := max(int64(tiktokPreferredChunkSize), (videoSize+tiktokMaxChunks-1)/tiktokMaxChunks)
+	if size > tiktokMaxChunkSize {
+		return tiktokChunkPlan{}, fmt.Errorf("video is too large for TikTok (%d bytes, the limit is %d)",
+			videoSize, int64(tiktokMaxChunkSize)*tiktokMaxChunks)
+	}
+	if videoSize <= size {
+		return tiktokChunkPlan{VideoSize: videoSize, Size: videoSize, Count: 1}, nil
+	}
+	return tiktokChunkPlan{VideoSize: videoSize, Size: size, Count: int(videoSize / size)}, nil
+}
+
+// Chunk returns the offset and length of chunk i, counting from 0
+func (p tiktokChunkPlan) Chunk(i int) (offset, length int64) {
+	offset = int64(i) * p.Size
+	if i == p.Count-1 {
+		return offset, p.VideoSize - offset
+	}
+	return offset, p.Size
+}
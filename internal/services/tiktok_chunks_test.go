@@ -0,0 +1,85 @@
+package services
+
+import "testing"
+
+const mib = 1 << 20
+
+func TestPlanTikTokChunks(t *testing.T) {
+	tests := []struct {
+		name      string
+		videoSize int64
+		size      int64 // Expected chunk_size
+		count     int   // Expected total_chunk_count
+		final     int64 // Expected length of the final chunk
+	}{
+		{"1 byte", 1, 1, 1, 1},
+		{"just under 5 MB", 5*mib - 1, 5*mib - 1, 1, 5*mib - 1},
+		{"exactly 5 MB", 5 * mib, 5 * mib, 1, 5 * mib},
+		{"between 5 and 10 MB", 7 * mib, 7 * mib, 1, 7 * mib},
+		{"exactly 10 MB", 10 * mib, 10 * mib, 1, 10 * mib},
+		// TikTok rounds the chunk count down, so the extra byte joins the only chunk
+		{"10 MB + 1", 10*mib + 1, 10 * mib, 1, 10*mib + 1},
+		{"several 10 MB chunks", 25 * mib, 10 * mib, 2, 15 * mib},
+		// 1500 chunks at 10 MB, so the chunks grow to fit in 1000
+		{"more than 1000 chunks at 10 MB", 15000*mib + 1, 15728641, 999, 31456283},
+		{"64 MB x 1000 ceiling", 64000 * mib, 64 * mib, 1000, 64 * mib},
+		{"final chunk between 64 and 128 MB", 64000*mib - 1, 64 * mib, 999, 128*mib - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planTikTokChunks(tt.videoSize)
+			if err != nil {
+				t.Fatalf("planTikTokChunks(%d): %v", tt.videoSize, err)
+			}
+			if plan.Size != tt.size || plan.Count != tt.count {
+				t.Errorf("got chunk size %d and count %d, want %d and %d", plan.Size, plan.Count, tt.size, tt.count)
+			}
+			if _, final := plan.Chunk(plan.Count - 1); final != tt.final {
+				t.Errorf("got final chunk of %d bytes, want %d", final, tt.final)
+			}
+			checkTikTokLimits(t, plan)
+		})
+	}
+}
+
+func TestPlanTikTokChunksErrors(t *testing.T) {
+	for _, size := range []int64{0, -1, 64000*mib + 1, 100000 * mib} {
+		if plan, err := planTikTokChunks(size); err == nil {
+			t.Errorf("planTikTokChunks(%d) = %+v, want an error", size, plan)
+		}
+	}
+}
+
+// checkTikTokLimits checks that the chunks cover the whole video without
+// gaps and stay within the limits of TikTok's media transfer protocol
+func checkTikTokLimits(t *testing.T, plan tiktokChunkPlan) {
+	t.Helper()
+
+	if plan.Count < 1 || plan.Count > tiktokMaxChunks {
+		t.Errorf("chunk count %d is outside 1..%d", plan.Count, tiktokMaxChunks)
+	}
+	if plan.Count > 1 && (plan.Size < tiktokMinChunkSize || plan.Size > tiktokMaxChunkSize) {
+		t.Errorf("chunk size %d is outside %d..%d", plan.Size, tiktokMinChunkSize, tiktokMaxChunkSize)
+	}
+	if plan.VideoSize/plan.Size != int64(plan.Count) {
+		t.Errorf("chunk count %d does not match TikTok's %d", plan.Count, plan.VideoSize/plan.Size)
+	}
+
+	var next int64
+	for i := 0; i < plan.Count; i++ {
+		offset, length := plan.Chunk(i)
+		if offset != next {
+			t.Fatalf("chunk %d starts at %d, want %d", i, offset, next)
+		}
+		if i < plan.Count-1 && length != plan.Size {
+			t.Fatalf("chunk %d is %d bytes, want %d", i, length, plan.Size)
+		}
+		if i == plan.Count-1 && length > tiktokMaxFinalChunkSize {
+			t.Errorf("final chunk is %d bytes, more than %d", length, tiktokMaxFinalChunkSize)
+		}
+		next = offset + length
+	}
+	if next != plan.VideoSize {
+		t.Errorf("chunks cover %d bytes of %d", next, plan.VideoSize)
+	}
+}
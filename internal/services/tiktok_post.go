@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"uploader/internal/config"
+	"uploader/internal/models"
+)
+
+// TikTokPrivacyLabels names the privacy levels TikTok may offer a creator
+var TikTokPrivacyLabels = map[string]string{
+	"PUBLIC_TO_EVERYONE":    "Everyone",
+	"MUTUAL_FOLLOW_FRIENDS": "Friends",
+	"FOLLOWER_OF_CREATOR":   "Followers",
+	"SELF_ONLY":             "Only me",
+}
+
+// tiktokPostOptions are the post settings chosen on the upload form
+type tiktokPostOptions struct {
+	Privacy        string
+	AllowComment   bool
+	AllowDuet      bool
+	AllowStitch    bool
+	Disclose       bool // The video promotes a brand, product or service
+	BrandOrganic   bool // Promotes the creator's own business
+	BrandedContent bool // Paid partnership promoting a third party
+}
+
+// tiktokOptionsFrom reads the TikTok fields of the upload form. Without a
+// privacy choice the configured privacy_level is used.
+func tiktokOptionsFrom(meta Metadata) tiktokPostOptions {
+	opts := tiktokPostOptions{
+		Privacy:        meta.Value("tiktokPrivacy"),
+		AllowComment:   meta.Value("tiktokAllowComment") != "",
+		AllowDuet:      meta.Value("tiktokAllowDuet") != "",
+		AllowStitch:    meta.Value("tiktokAllowStitch") != "",
+		Disclose:       meta.Value("tiktokDisclose") != "",
+		BrandOrganic:   meta.Value("tiktokBrandOrganic") != "",
+		BrandedContent: meta.Value("tiktokBrandedContent") != "",
+	}
+	if opts.Privacy == "" {
+		opts.Privacy = config.Get().TikTok.PrivacyLevel
+	}
+	if !opts.Disclose {
+		opts.BrandOrganic, opts.BrandedContent = false, false
+	}
+	return opts
+}
+
+// validate checks the options that do not depend on the creator
+func (o tiktokPostOptions) validate() error {
+	if _, ok := TikTokPrivacyLabels[o.Privacy]; !ok {
+		return fmt.Errorf("unknown TikTok privacy level %q", o.Privacy)
+	}
+	if o.Disclose && !o.BrandOrganic && !o.BrandedContent {
+		return fmt.Errorf("choose whether the TikTok video promotes your brand, a third party or both")
+	}
+	if o.BrandedContent && o.Privacy == "SELF_ONLY" {
+		return fmt.Errorf("branded content on TikTok cannot be private, choose another privacy level")
+	}
+	return nil
+}
+
+// postInfo returns the post_info of the init request. Interactions the
+// creator has turned off in the TikTok app stay off whatever was chosen.
+func (o tiktokPostOptions) postInfo(title string, creator models.TikTokCreatorInfo) (map[string]interface{}, error) {
+	if !slices.Contains(creator.PrivacyLevelOptions, o.Privacy) {
+		return nil, fmt.Errorf("this TikTok account cannot post with privacy %q", TikTokPrivacyLabels[o.Privacy])
+	}
+	return map[string]interface{}{
+		"title":                title,
+		"privacy_level":        o.Privacy,
+		"disable_comment":      !o.AllowComment || creator.CommentDisabled,
+		"disable_duet":         !o.AllowDuet || creator.DuetDisabled,
+		"disable_stitch":       !o.AllowStitch || creator.StitchDisabled,
+		"brand_organic_toggle": o.BrandOrganic,
+		"brand_content_toggle": o.BrandedContent,
+	}, nil
+}
+
+// CreatorInfo asks TikTok which privacy levels and interactions the
+// account may currently post with
+func (t *TikTok) CreatorInfo(ctx context.Context, userID, accountID string) (models.TikTokCreatorInfo, error) {
+	token, err := t.tokens.Token(ctx, userID, t.Name(), accountID)
+	if err != nil {
+		return models.TikTokCreatorInfo{}, NewAPIError(models.ErrorAuth, fmt.Errorf("user not authenticated with TikTok: %v", err))
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var info models.TikTokCreatorInfo
+	err = DefaultRetryPolicy.Do(ctx, "TikTok creator info query", func() error {
+		var err error
+		info, err = queryCreatorInfo(ctx, client, token.AccessToken)
+		return err
+	})
+	return info, err
+}
+
+// queryCreatorInfo calls the creator_info endpoint once
+func queryCreatorInfo(ctx context.Context, client *http.Client, accessToken string) (models.TikTokCreatorInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://open.tiktokapis.com/v2/post/publish/creator_info/query/", bytes.NewReader(nil))
+	if err != nil {
+		return models.TikTokCreatorInfo{}, fmt.Errorf("failed to create creator info request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return models.TikTokCreatorInfo{}, NewAPIError(models.ErrorTransient, fmt.Errorf("failed to query creator info: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if err := CheckResponse(resp); err != nil {
+		return models.TikTokCreatorInfo{}, fmt.Errorf("failed to query creator info: %w", err)
+	}
+
+	var infoResponse struct {
+		Data  models.TikTokCreatorInfo `json:"data"`
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&infoResponse); err != nil {
+		return models.TikTokCreatorInfo{}, fmt.Errorf("failed to parse creator info: %v", err)
+	}
+	if code := infoResponse.Error.Code; code != "" && code != "ok" {
+		return models.TikTokCreatorInfo{}, NewAPIError(classifyResponse(http.StatusBadRequest, code),
+			fmt.Errorf("TikTok API error: %s (code: %s)", infoResponse.Error.Message, code))
+	}
+	return infoResponse.Data, nil
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"uploader/internal/models"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+// youtubeCaptionField prefixes the upload form fields of caption files.
+// Every file comes in its own numbered field, e.g. "youtubeCaption1", with
+// its language in the matching "youtubeCaptionLanguage1".
+const (
+	youtubeCaptionField         = "youtubeCaption"
+	youtubeCaptionLanguageField = "youtubeCaptionLanguage"
+)
+
+// youtubeMaxCaptionSize is YouTube's limit for caption files
+const youtubeMaxCaptionSize = 100 << 20
+
+// youtubeCaption is a caption file submitted with the video
+type youtubeCaption struct {
+	File     *Attachment
+	Language string
+}
+
+// captionsFrom returns the caption files of the upload in form order
+func captionsFrom(media *Media, meta Metadata) []youtubeCaption {
+	var fields []string
+	for field := range media.Attachments {
+		if n, ok := strings.CutPrefix(field, youtubeCaptionField); ok && n != "" {
+			fields = append(fields, field)
+		}
+	}
+	slices.SortFunc(fields, cmpNumbered)
+
+	var captions []youtubeCaption
+	for _, field := range fields {
+		suffix := strings.TrimPrefix(field, youtubeCaptionField)
+		captions = append(captions, youtubeCaption{
+			File:     media.Attachments[field],
+			Language: strings.TrimSpace(meta.Value(youtubeCaptionLanguageField + suffix)),
+		})
+	}
+	return captions
+}
+
+// cmpNumbered orders field names that differ only in a trailing number
+func cmpNumbered(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+// validate checks a caption file before anything is uploaded
+func (c youtubeCaption) validate() error {
+	switch strings.ToLower(filepath.Ext(c.File.Filename)) {
+	case ".srt", ".vtt":
+	default:
+		return fmt.Errorf("caption file %s must be SRT or WebVTT", c.File.Filename)
+	}
+	if c.File.Size > youtubeMaxCaptionSize {
+		return fmt.Errorf("caption file %s is larger than 100 MB", c.File.Filename)
+	}
+	if c.Language == "" {
+		return fmt.Errorf("choose the language of caption file %s", c.File.Filename)
+	}
+	if !languageCode.MatchString(c.Language) {
+		return fmt.Errorf("invalid language %q for caption file %s, use a code such as en or pt-BR", c.Language, c.File.Filename)
+	}
+	return nil
+}
+
+// insertCaptions adds the caption tracks to the uploaded video and reports
+// the outcome per track
+func insertCaptions(ctx context.Context, service *youtube.Service, videoID string, captions []youtubeCaption) []models.SubStatus {
+	ReportProgress(ctx, models.Progress{Phase: "uploading captions"})
+
+	var statuses []models.SubStatus
+	for _, c := range captions {
+		caption := &youtube.Caption{
+			Snippet: &youtube.CaptionSnippet{VideoId: videoID, Language: c.Language},
+		}
+		err := DefaultRetryPolicy.Do(ctx, "YouTube caption insert", func() error {
+			file, err := c.File.Open()
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			_, err = service.Captions.Insert([]string{"snippet"}, caption).
+				Media(file, googleapi.ContentType("application/octet-stream")).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to add %s captions to YouTube video %s: %v", c.Language, videoID, err)
+			err = fmt.Errorf("failed to upload the captions: %w", err)
+		} else {
+			log.Printf("Added %s captions to YouTube video %s", c.Language, videoID)
+		}
+		statuses = append(statuses, NewSubStatus(fmt.Sprintf("Captions %s (%s)", c.Language, c.File.Filename), err))
+	}
+	return statuses
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"uploader/internal/config"
+	"uploader/internal/models"
+	"uploader/internal/tokens"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// youtubeCategoriesTTL is how long the category list is reused; YouTube
+// rarely changes it
+const youtubeCategoriesTTL = 24 * time.Hour
+
+// youtubeMaxTagsLength is YouTube's limit on the tags of a video, counting
+// a comma between tags and quotes around tags that contain spaces
+const youtubeMaxTagsLength = 500
+
+// languageCode matches BCP-47 codes such as "en" or "pt-BR"
+var languageCode = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// youtubeVideoOptions are the video details chosen on the upload form
+type youtubeVideoOptions struct {
+	Privacy     string
+	PublishAt   time.Time // Zero unless the video is scheduled
+	Tags        []string
+	CategoryID  string
+	Language    string
+	MadeForKids string // "yes", "no" or "" for the channel default
+	License     string
+	Embeddable  *bool // Nil leaves the channel default
+	PublicStats *bool
+}
+
+// youtubeOptionsFrom reads the YouTube fields of the upload form, falling
+// back to the configured privacy and category
+func youtubeOptionsFrom(meta Metadata) (youtubeVideoOptions, error) {
+	cfg := config.Get().YouTube
+	opts := youtubeVideoOptions{
+		Privacy:     meta.Value("youtubePrivacy"),
+		CategoryID:  meta.Value("youtubeCategory"),
+		Language:    strings.TrimSpace(meta.Value("youtubeLanguage")),
+		MadeForKids: meta.Value("youtubeMadeForKids"),
+		License:     meta.Value("youtubeLicense"),
+		Embeddable:  optionalFlag(meta, "youtubeEmbeddable"),
+		PublicStats: optionalFlag(meta, "youtubePublicStats"),
+	}
+	if opts.Privacy == "" {
+		opts.Privacy = cfg.PrivacyStatus
+	}
+	if opts.CategoryID == "" {
+		opts.CategoryID = cfg.CategoryID
+	}
+	if opts.License == "" {
+		opts.License = "youtube"
+	}
+	for _, tag := range strings.Split(meta.Value("youtubeTags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			opts.Tags = append(opts.Tags, tag)
+		}
+	}
+	if at := meta.Value("youtubePublishAt"); at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return opts, fmt.Errorf("invalid YouTube publish time %q", at)
+		}
+		opts.PublishAt = t
+	}
+	return opts, nil
+}
+
+// optionalFlag reads a checkbox that the form pairs with a hidden "off"
+// field, so an unchecked box can be told apart from a form without it. It
+// returns nil when the field was not sent at all
+func optionalFlag(meta Metadata, key string) *bool {
+	values, ok := meta.Values[key]
+	if !ok {
+		return nil
+	}
+	on := slices.Contains(values, "on")
+	return &on
+}
+
+// validate checks the options against YouTube's rules
+func (o youtubeVideoOptions) validate() error {
+	switch o.Privacy {
+	case "private", "unlisted", "public":
+	default:
+		return fmt.Errorf("unknown YouTube privacy %q", o.Privacy)
+	}
+	if !o.PublishAt.IsZero() {
+		if o.Privacy != "private" {
+			return fmt.Errorf("scheduled YouTube videos must be private until they are published")
+		}
+		if !o.PublishAt.After(time.Now()) {
+			return fmt.Errorf("the YouTube publish time must be in the future")
+		}
+	}
+
+	length := 0
+	for _, tag := range o.Tags {
+		length += len(tag)
+		if strings.Contains(tag, " ") {
+			length += 2
+		}
+	}
+	if length += max(len(o.Tags)-1, 0); length > youtubeMaxTagsLength {
+		return fmt.Errorf("YouTube tags are too long (%d characters, the limit is %d)", length, youtubeMaxTagsLength)
+	}
+
+	if o.Language != "" && !languageCode.MatchString(o.Language) {
+		return fmt.Errorf("invalid YouTube language %q, use a code such as en or pt-BR", o.Language)
+	}
+	switch o.MadeForKids {
+	case "", "yes", "no":
+	default:
+		return fmt.Errorf("invalid YouTube audience %q", o.MadeForKids)
+	}
+	switch o.License {
+	case "youtube", "creativeCommon":
+	default:
+		return fmt.Errorf("unknown YouTube license %q", o.License)
+	}
+	return nil
+}
+
+// video returns the metadata of the video to insert
+func (o youtubeVideoOptions) video(title, description string) *youtube.Video {
+	video := &youtube.Video{
+		Snippet: &youtube.VideoSnippet{
+			Title:           title,
+			Description:     description,
+			Tags:            o.Tags,
+			CategoryId:      o.CategoryID,
+			DefaultLanguage: o.Language,
+		},
+		Status: &youtube.VideoStatus{
+			PrivacyStatus: o.Privacy,
+			License:       o.License,
+		},
+	}
+	if !o.PublishAt.IsZero() {
+		video.Status.PublishAt = o.PublishAt.UTC().Format(time.RFC3339)
+	}
+	// Send false as well when it was chosen, otherwise YouTube applies its
+	// defaults
+	if o.Embeddable != nil {
+		video.Status.Embeddable = *o.Embeddable
+		video.Status.ForceSendFields = append(video.Status.ForceSendFields, "Embeddable")
+	}
+	if o.PublicStats != nil {
+		video.Status.PublicStatsViewable = *o.PublicStats
+		video.Status.ForceSendFields = append(video.Status.ForceSendFields, "PublicStatsViewable")
+	}
+	if o.MadeForKids != "" {
+		video.Status.SelfDeclaredMadeForKids = o.MadeForKids == "yes"
+		video.Status.ForceSendFields = append(video.Status.ForceSendFields, "SelfDeclaredMadeForKids")
+	}
+	return video
+}
+
+// Categories returns the video categories uploads can be assigned to in the
+// configured region, using the account's token to ask YouTube
+func (y *YouTube) Categories(ctx context.Context, userID, accountID string) ([]models.YouTubeCategory, error) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	if y.categories != nil && time.Since(y.categoriesFetched) < youtubeCategoriesTTL {
+		return y.categories, nil
+	}
+
+	token, err := y.tokens.Token(ctx, userID, y.Name(), accountID)
+	if err != nil {
+		return nil, NewAPIError(models.ErrorAuth, fmt.Errorf("YouTube authentication required: %v", err))
+	}
+	service, err := newYouTubeService(ctx, config.Get().YouTubeOAuthConfig.Client(ctx, tokens.OAuth2(token)))
+	if err != nil {
+		return nil, err
+	}
+
+	var resp *youtube.VideoCategoryListResponse
+	err = DefaultRetryPolicy.Do(ctx, "YouTube category list", func() error {
+		var err error
+		resp, err = service.VideoCategories.List([]string{"snippet"}).RegionCode(config.Get().YouTube.RegionCode).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list YouTube categories: %w", err)
+	}
+
+	var categories []models.YouTubeCategory
+	for _, c := range resp.Items {
+		if c.Snippet != nil && c.Snippet.Assignable {
+			categories = append(categories, models.YouTubeCategory{ID: c.Id, Title: c.Snippet.Title})
+		}
+	}
+	y.categories, y.categoriesFetched = categories, time.Now()
+	return categories, nil
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestYouTubeVideoOptionalFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		values url.Values
+		want   *bool // Embeddable sent to YouTube, nil when left out
+	}{
+		{"field missing", url.Values{}, nil},
+		{"unchecked", url.Values{"youtubeEmbeddable": {"off"}}, ptr(false)},
+		{"checked", url.Values{"youtubeEmbeddable": {"off", "on"}}, ptr(true)},
+		{"checked without hidden field", url.Values{"youtubeEmbeddable": {"on"}}, ptr(true)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := youtubeVideoOptions{Embeddable: optionalFlag(Metadata{Values: tt.values}, "youtubeEmbeddable")}
+			status := opts.video("title", "").Status
+
+			sent := slices.Contains(status.ForceSendFields, "Embeddable")
+			if sent != (tt.want != nil) {
+				t.Fatalf("Embeddable sent = %v, want %v", sent, tt.want != nil)
+			}
+			if tt.want != nil && status.Embeddable != *tt.want {
+				t.Errorf("Embeddable = %v, want %v", status.Embeddable, *tt.want)
+			}
+			if slices.Contains(status.ForceSendFields, "PublicStatsViewable") {
+				t.Error("PublicStatsViewable sent without the field on the form")
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
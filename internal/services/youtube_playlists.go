@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"uploader/internal/config"
+	"uploader/internal/models"
+	"uploader/internal/tokens"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+// youtubePlaylistField is the upload form field of the chosen playlists.
+// Every value is "<account ID>:<playlist ID>", since a playlist belongs to
+// one of the connected channels.
+const youtubePlaylistField = "youtubePlaylist"
+
+// YouTubePlaylistValue returns the form value that adds a video uploaded to
+// the account to the playlist
+func YouTubePlaylistValue(accountID, playlistID string) string {
+	return accountID + ":" + playlistID
+}
+
+// chosenPlaylists returns the IDs of the playlists chosen for the account
+func chosenPlaylists(meta Metadata, accountID string) []string {
+	var ids []string
+	for _, value := range meta.Values[youtubePlaylistField] {
+		account, playlist, ok := strings.Cut(value, ":")
+		if ok && account == accountID && playlist != "" {
+			ids = append(ids, playlist)
+		}
+	}
+	return ids
+}
+
+// Playlists returns the playlists of the connected channel
+func (y *YouTube) Playlists(ctx context.Context, userID, accountID string) ([]models.YouTubePlaylist, error) {
+	token, err := y.tokens.Token(ctx, userID, y.Name(), accountID)
+	if err != nil {
+		return nil, NewAPIError(models.ErrorAuth, fmt.Errorf("YouTube authentication required: %v", err))
+	}
+	service, err := newYouTubeService(ctx, config.Get().YouTubeOAuthConfig.Client(ctx, tokens.OAuth2(token)))
+	if err != nil {
+		return nil, err
+	}
+	return listPlaylists(ctx, service)
+}
+
+// listPlaylists returns every playlist of the authenticated channel
+func listPlaylists(ctx context.Context, service *youtube.Service) ([]models.YouTubePlaylist, error) {
+	var playlists []models.YouTubePlaylist
+	call := service.Playlists.List([]string{"snippet"}).Mine(true).MaxResults(50)
+	for pageToken := ""; ; {
+		var resp *youtube.PlaylistListResponse
+		err := DefaultRetryPolicy.Do(ctx, "YouTube playlist list", func() error {
+			var err error
+			resp, err = call.PageToken(pageToken).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list YouTube playlists: %w", err)
+		}
+
+		for _, p := range resp.Items {
+			if p.Snippet != nil {
+				playlists = append(playlists, models.YouTubePlaylist{ID: p.Id, Title: p.Snippet.Title})
+			}
+		}
+		if pageToken = resp.NextPageToken; pageToken == "" {
+			return playlists, nil
+		}
+	}
+}
+
+// addToPlaylists adds the uploaded video to each of the playlists and
+// reports the outcome per playlist
+func addToPlaylists(ctx context.Context, service *youtube.Service, videoID string, playlistIDs []string) []models.SubStatus {
+	ReportProgress(ctx, models.Progress{Phase: "adding to playlists"})
+
+	var statuses []models.SubStatus
+	for _, playlistID := range playlistIDs {
+		item := &youtube.PlaylistItem{
+			Snippet: &youtube.PlaylistItemSnippet{
+				PlaylistId: playlistID,
+				ResourceId: &youtube.ResourceId{Kind: "youtube#video", VideoId: videoID},
+			},
+		}
+		attempt := 0
+		err := DefaultRetryPolicy.Do(ctx, "YouTube playlist insert", func() error {
+			// Inserting twice adds the video twice, and a failed attempt may
+			// still have reached YouTube, so retries check for it first
+			if attempt++; attempt > 1 {
+				added, err := inPlaylist(ctx, service, playlistID, videoID)
+				if err != nil || added {
+					return err
+				}
+			}
+			_, err := service.PlaylistItems.Insert([]string{"snippet"}, item).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to add YouTube video %s to playlist %s: %v", videoID, playlistID, err)
+			err = fmt.Errorf("failed to add the video: %w", err)
+		} else {
+			log.Printf("Added YouTube video %s to playlist %s", videoID, playlistID)
+		}
+		statuses = append(statuses, NewSubStatus("Playlist "+playlistID, err))
+	}
+	return statuses
+}
+
+// inPlaylist reports whether the video is already an item of the playlist
+func inPlaylist(ctx context.Context, service *youtube.Service, playlistID, videoID string) (bool, error) {
+	resp, err := service.PlaylistItems.List([]string{"id"}).PlaylistId(playlistID).VideoId(videoID).Context(ctx).Do()
+	if err != nil {
+		return false, err
+	}
+	return len(resp.Items) > 0, nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/youtube/v3"
+)
+
+func TestAddToPlaylistsRetry(t *testing.T) {
+	old := DefaultRetryPolicy
+	DefaultRetryPolicy = testPolicy
+	t.Cleanup(func() { DefaultRetryPolicy = old })
+
+	tests := []struct {
+		name    string
+		added   bool // Whether the failed insert still reached YouTube
+		inserts int
+	}{
+		{"failed insert added the video", true, 1},
+		{"failed insert did not add the video", false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inserts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasSuffix(r.URL.Path, "/playlistItems") {
+					http.NotFound(w, r)
+					return
+				}
+				switch r.Method {
+				case http.MethodPost:
+					// The first insert fails, whether or not it added the video
+					if inserts++; inserts == 1 {
+						http.Error(w, `{"error":{"code":503,"message":"backend error"}}`, http.StatusServiceUnavailable)
+						return
+					}
+					w.Write([]byte(`{"id":"item2"}`))
+				case http.MethodGet:
+					if r.URL.Query().Get("playlistId") != "PL1" || r.URL.Query().Get("videoId") != "vid1" {
+						t.Errorf("listed items with %s", r.URL.RawQuery)
+					}
+					if tt.added {
+						w.Write([]byte(`{"items":[{"id":"item1"}]}`))
+					} else {
+						w.Write([]byte(`{"items":[]}`))
+					}
+				}
+			}))
+			defer srv.Close()
+
+			service, err := youtube.NewService(context.Background(), option.WithEndpoint(srv.URL+"/"), option.WithHTTPClient(srv.Client()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			statuses := addToPlaylists(context.Background(), service, "vid1", []string{"PL1"})
+
+			if len(statuses) != 1 || !statuses[0].Success || statuses[0].Name != "Playlist PL1" {
+				t.Errorf("got statuses %+v, want one success for Playlist PL1", statuses)
+			}
+			if inserts != tt.inserts {
+				t.Errorf("inserted %d times, want %d", inserts, tt.inserts)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"uploader/internal/models"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+const (
+	youtubeUploadURL = "https://www.googleapis.com/upload/youtube/v3/videos?uploadType=resumable&part=snippet,status"
+
+	// youtubeChunkSize is how much of the video is sent per request. The
+	// committed offset is checkpointed after every chunk, so at most this
+	// much is sent again after a failure. Must be a multiple of 256 KiB.
+	youtubeChunkSize = 32 << 20
+)
+
+// errSessionExpired means YouTube no longer knows the upload session and
+// the upload has to start over
+var errSessionExpired = errors.New("YouTube upload session expired")
+
+// youtubeSession is the checkpointed state of a resumable upload
+type youtubeSession struct {
+	URI    string `json:"uri"`    // Session URI returned when the upload was started
+	Size   int64  `json:"size"`   // Size of the video the session was started for
+	Offset int64  `json:"offset"` // Bytes YouTube has confirmed receiving
+}
+
+// resumableUpload sends the video using YouTube's resumable upload
+// protocol. The session is saved to the checkpoint in ctx, so an upload
+// that was interrupted, even by a server restart, continues from the last
+// confirmed byte instead of starting over.
+func resumableUpload(ctx context.Context, client *http.Client, media *Media, video *youtube.Video) (*youtube.Video, error) {
+	cp := CheckpointFrom(ctx)
+
+	// YouTube answers incomplete uploads with 308, which must not be
+	// followed like a redirect
+	noRedirect := *client
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	client = &noRedirect
+
+	var session youtubeSession
+	if found, err := cp.Load(&session); err != nil {
+		log.Printf("YouTube upload: ignoring unreadable checkpoint: %v", err)
+		session = youtubeSession{}
+	} else if found && (session.URI == "" || session.Size != media.Size) {
+		log.Printf("YouTube upload: ignoring checkpoint of a different video")
+		session = youtubeSession{}
+	} else if found {
+		log.Printf("Resuming YouTube upload at byte %d of %d", session.Offset, session.Size)
+	}
+
+	file, err := media.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	policy := DefaultRetryPolicy
+	failures, confirmed := 0, session.Offset
+	for {
+		var uploaded *youtube.Video
+		if session.URI == "" {
+			session, err = startSession(ctx, client, media, video)
+			if err == nil {
+				if err := cp.Save(session); err != nil {
+					log.Printf("YouTube upload: failed to save checkpoint: %v", err)
+				}
+				uploaded, err = sendChunks(ctx, client, file, &session, cp)
+			}
+		} else {
+			// The checkpoint may lag behind what YouTube received
+			uploaded, err = querySession(ctx, client, &session)
+			if err == nil && uploaded == nil {
+				uploaded, err = sendChunks(ctx, client, file, &session, cp)
+			}
+		}
+		if err == nil {
+			if err := cp.Clear(); err != nil {
+				log.Printf("YouTube upload: %v", err)
+			}
+			return uploaded, nil
+		}
+
+		if errors.Is(err, errSessionExpired) {
+			log.Printf("YouTube upload session expired, starting over")
+			session = youtubeSession{}
+			if err := cp.Clear(); err != nil {
+				log.Printf("YouTube upload: %v", err)
+			}
+		} else if !Retryable(err) {
+			return nil, err
+		}
+
+		// Only count failures that made no progress, so a long upload
+		// survives any number of separate network blips
+		if session.Offset > confirmed {
+			failures, confirmed = 0, session.Offset
+		}
+		failures++
+		if failures >= policy.MaxAttempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", failures, err)
+		}
+		if waitErr := policy.Wait(ctx, "YouTube upload", failures, err); waitErr != nil {
+			return nil, waitError(waitErr, err)
+		}
+	}
+}
+
+// startSession creates a new upload session for the video
+func startSession(ctx context.Context, client *http.Client, media *Media, video *youtube.Video) (youtubeSession, error) {
+	body, err := json.Marshal(video)
+	if err != nil {
+		return youtubeSession{}, fmt.Errorf("failed to encode video metadata: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", youtubeUploadURL, bytes.NewReader(body))
+	if err != nil {
+		return youtubeSession{}, fmt.Errorf("failed to create upload session request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(media.Size, 10))
+	req.Header.Set("X-Upload-Content-Type", "video/mp4")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return youtubeSession{}, NewAPIError(models.ErrorTransient, fmt.Errorf("failed to start upload session: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if err := checkGoogleResponse(resp); err != nil {
+		return youtubeSession{}, err
+	}
+	uri := resp.Header.Get("Location")
+	if uri == "" {
+		return youtubeSession{}, fmt.Errorf("YouTube did not return an upload session URI")
+	}
+
+	log.Printf("YouTube upload session started")
+	return youtubeSession{URI: uri, Size: media.Size}, nil
+}
+
+// querySession asks YouTube how much of the video it has received and
+// updates the session offset. It returns the video if the upload is
+// already complete.
+func querySession(ctx context.Context, client *http.Client, session *youtubeSession) (*youtube.Video, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", session.URI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload status request: %v", err)
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", session.Size))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, NewAPIError(models.ErrorTransient, fmt.Errorf("failed to query upload status: %v", err))
+	}
+	defer resp.Body.Close()
+	return handleChunkResponse(resp, session)
+}
+
+// sendChunks uploads the rest of the video starting at the session offset,
+// checkpointing the offset after every chunk
+func sendChunks(ctx context.Context, client *http.Client, file io.ReaderAt, session *youtubeSession, cp Checkpoint) (*youtube.Video, error) {
+	for {
+		start := session.Offset
+		n := min(int64(youtubeChunkSize), session.Size-start)
+
+		body := &ProgressReader{
+			Reader:  io.NewSectionReader(file, start, n),
+			Total:   session.Size,
+			Current: start,
+			OnProgress: func(current, total int64) {
+				ReportProgress(ctx, models.Progress{Phase: "uploading", BytesSent: current, TotalBytes: total})
+			},
+		}
+		req, err := http.NewRequestWithContext(ctx, "PUT", session.URI, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create upload request: %v", err)
+		}
+		req.ContentLength = n
+		req.Header.Set("Content-Type", "video/mp4")
+		if n > 0 {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, session.Size))
+		}
+
+		log.Printf("YouTube upload: sending bytes %d-%d of %d", start, start+n-1, session.Size)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, NewAPIError(models.ErrorTransient, fmt.Errorf("failed to send video data: %v", err))
+		}
+		video, err := handleChunkResponse(resp, session)
+		resp.Body.Close()
+		if err != nil || video != nil {
+			return video, err
+		}
+
+		if err := cp.Save(*session); err != nil {
+			log.Printf("YouTube upload: failed to save checkpoint: %v", err)
+		}
+		if session.Offset <= start {
+			// YouTube kept none of the chunk; let the caller back off
+			return nil, NewAPIError(models.ErrorTransient, fmt.Errorf("YouTube did not accept bytes from offset %d", start))
+		}
+	}
+}
+
+// handleChunkResponse interprets the reply to a chunk or status request.
+// An incomplete upload updates the session offset and returns no video.
+func handleChunkResponse(resp *http.Response, session *youtubeSession) (*youtube.Video, error) {
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+		var video youtube.Video
+		if err := json.NewDecoder(resp.Body).Decode(&video); err != nil {
+			return nil, fmt.Errorf("failed to decode uploaded video: %v", err)
+		}
+		return &video, nil
+	case resp.StatusCode == http.StatusPermanentRedirect: // "Resume Incomplete"
+		session.Offset = committedOffset(resp.Header.Get("Range"))
+		return nil, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, errSessionExpired
+	}
+	return nil, checkGoogleResponse(resp)
+}
+
+// committedOffset parses the Range header of a 308 response, e.g.
+// "bytes=0-1048575", into the number of bytes received
+func committedOffset(header string) int64 {
+	_, end, ok := strings.Cut(strings.TrimPrefix(header, "bytes="), "-")
+	if !ok {
+		return 0
+	}
+	last, err := strconv.ParseInt(end, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return last + 1
+}
+
+// checkGoogleResponse is CheckResponse for Google APIs: it parses the error
+// body as a googleapi.Error, keeping reasons such as "invalidContent", and
+// classifies it. Callers handle 308 Resume Incomplete before calling it.
+func checkGoogleResponse(resp *http.Response) error {
+	err := googleapi.CheckResponse(resp)
+	if err == nil {
+		return nil
+	}
+	return &APIError{
+		Kind:       Classify(err),
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		Err:        err,
+	}
+}
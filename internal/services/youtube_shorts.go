@@ -0,0 +1,62 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"uploader/internal/config"
+	"uploader/internal/models"
+)
+
+// youtubeShortField is the upload form checkbox that posts the video as a Short
+const youtubeShortField = "youtubeShort"
+
+// shortsTag is added to the title or description of videos posted as Shorts
+const shortsTag = "#Shorts"
+
+// youtubeMaxTitleLength is YouTube's limit on video titles
+const youtubeMaxTitleLength = 100
+
+// ShortsEligible reports whether YouTube shows a video as a Short: it must
+// be vertical or square and no longer than the configured limit
+func ShortsEligible(info *models.VideoInfo) bool {
+	return info != nil && info.Vertical() && info.Duration <= config.Get().YouTube.ShortsMaxDuration()
+}
+
+// Warnings implements Warner. Videos marked as Shorts that YouTube will
+// not show as one are uploaded anyway, as regular videos.
+func (y *YouTube) Warnings(media *Media, meta Metadata) []string {
+	if meta.Value(youtubeShortField) == "" {
+		return nil
+	}
+
+	info := media.Video
+	if info == nil {
+		return []string{"Could not read the length and size of the video to check that it can be a Short"}
+	}
+	var warnings []string
+	if limit := config.Get().YouTube.ShortsMaxDuration(); info.Duration > limit {
+		warnings = append(warnings, fmt.Sprintf("The video is %s long, Shorts can be at most %s", info.Length(), models.VideoInfo{Duration: limit}.Length()))
+	}
+	if !info.Vertical() {
+		warnings = append(warnings, fmt.Sprintf("The video is %d×%d, Shorts must be vertical or square", info.Width, info.Height))
+	}
+	return warnings
+}
+
+// withShortsTag adds #Shorts to the title of a video posted as a Short, or
+// to its description if the title has no room left
+func withShortsTag(title, description string) (string, string) {
+	for _, s := range []string{title, description} {
+		if strings.Contains(strings.ToLower(s), strings.ToLower(shortsTag)) {
+			return title, description
+		}
+	}
+	if len([]rune(title))+1+len(shortsTag) <= youtubeMaxTitleLength {
+		return title + " " + shortsTag, description
+	}
+	if description == "" {
+		return title, shortsTag
+	}
+	return title, description + "\n\n" + shortsTag
+}
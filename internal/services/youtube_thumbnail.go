@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"uploader/internal/models"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/youtube/v3"
+)
+
+// youtubeThumbnailField is the upload form field of the optional thumbnail
+const youtubeThumbnailField = "youtubeThumbnail"
+
+// youtubeMaxThumbnailSize is YouTube's limit for custom thumbnails
+const youtubeMaxThumbnailSize = 2 << 20
+
+// youtubeThumbnailTypes are the image types YouTube accepts as thumbnails
+var youtubeThumbnailTypes = []string{"image/jpeg", "image/png"}
+
+// validateThumbnail checks a thumbnail before anything is uploaded
+func validateThumbnail(thumb *Attachment) error {
+	if !slices.Contains(youtubeThumbnailTypes, thumb.ContentType) {
+		return fmt.Errorf("the YouTube thumbnail must be a JPEG or PNG image, %s is %s", thumb.Filename, thumb.ContentType)
+	}
+	if thumb.Size > youtubeMaxThumbnailSize {
+		return fmt.Errorf("the YouTube thumbnail must be at most 2 MB, %s is larger", thumb.Filename)
+	}
+	return nil
+}
+
+// setThumbnail sets the custom thumbnail of an uploaded video. YouTube
+// only allows this for channels that are verified.
+func setThumbnail(ctx context.Context, service *youtube.Service, videoID string, thumb *Attachment) models.SubStatus {
+	ReportProgress(ctx, models.Progress{Phase: "setting thumbnail"})
+
+	err := DefaultRetryPolicy.Do(ctx, "YouTube thumbnail", func() error {
+		file, err := thumb.Open()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = service.Thumbnails.Set(videoID).Media(file, googleapi.ContentType(thumb.ContentType)).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to set YouTube thumbnail of video %s: %v", videoID, err)
+		return NewSubStatus("Thumbnail", fmt.Errorf("failed to set the thumbnail: %w", err))
+	}
+
+	log.Printf("Thumbnail set for YouTube video %s", videoID)
+	return NewSubStatus("Thumbnail", nil)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"uploader/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned when a record does not exist
+var ErrNotFound = errors.New("not found")
+
+var jobsBucket = []byte("jobs")
+
+// Store persists upload history in a BoltDB file
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens (or creates) the database at path
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database '%s': %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{jobsBucket, usersBucket, usernamesBucket, sessionsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database '%s': %w", path, err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SaveJob inserts or replaces a job record
+func (s *Store) SaveJob(job models.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job %s: %w", job.ID, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.ID), data)
+	})
+}
+
+// GetJob returns the job with the given ID, or ErrNotFound
+func (s *Store) GetJob(id string) (models.Job, error) {
+	var job models.Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(jobsBucket), []byte(id), &job)
+	})
+	return job, err
+}
+
+// ListJobs returns up to limit jobs of the user, newest first. An empty
+// userID matches every user and a limit of 0 returns all matching jobs.
+func (s *Store) ListJobs(userID string, limit int) ([]models.Job, error) {
+	var jobs []models.Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var job models.Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return fmt.Errorf("failed to decode job %s: %w", k, err)
+			}
+			if userID == "" || job.UserID == userID {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
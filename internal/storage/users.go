@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"uploader/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// ErrUsernameTaken is returned by CreateUser when the username is already in use
+	ErrUsernameTaken = errors.New("username is already taken")
+	// ErrUsersExist is returned by CreateFirstUser once any user has registered
+	ErrUsersExist = errors.New("an account already exists")
+)
+
+var (
+	usersBucket     = []byte("users")     // User ID -> models.User
+	usernamesBucket = []byte("usernames") // Lower-cased username -> user ID
+	sessionsBucket  = []byte("sessions")  // Hashed session token -> models.Session
+)
+
+// CreateUser saves a new user, failing if the username is taken
+func (s *Store) CreateUser(user models.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode user %s: %w", user.ID, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putUser(tx, user.ID, user.Username, data)
+	})
+}
+
+// CreateFirstUser saves a new user only if no user exists yet. The check
+// and the insert share one transaction, so of two concurrent calls only one
+// succeeds and the other gets ErrUsersExist.
+func (s *Store) CreateFirstUser(user models.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode user %s: %w", user.ID, err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if k, _ := tx.Bucket(usersBucket).Cursor().First(); k != nil {
+			return ErrUsersExist
+		}
+		return putUser(tx, user.ID, user.Username, data)
+	})
+}
+
+// putUser stores an encoded user and claims its username
+func putUser(tx *bolt.Tx, id, username string, data []byte) error {
+	names := tx.Bucket(usernamesBucket)
+	key := []byte(strings.ToLower(username))
+	if names.Get(key) != nil {
+		return ErrUsernameTaken
+	}
+	if err := names.Put(key, []byte(id)); err != nil {
+		return err
+	}
+	return tx.Bucket(usersBucket).Put([]byte(id), data)
+}
+
+// GetUser returns the user with the given ID, or ErrNotFound
+func (s *Store) GetUser(id string) (models.User, error) {
+	var user models.User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(usersBucket), []byte(id), &user)
+	})
+	return user, err
+}
+
+// GetUserByUsername returns the user with the given username, ignoring case, or ErrNotFound
+func (s *Store) GetUserByUsername(username string) (models.User, error) {
+	var user models.User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(usernamesBucket).Get([]byte(strings.ToLower(username)))
+		if id == nil {
+			return ErrNotFound
+		}
+		return getJSON(tx.Bucket(usersBucket), id, &user)
+	})
+	return user, err
+}
+
+// CountUsers returns the number of registered users
+func (s *Store) CountUsers() (int, error) {
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(usersBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// SaveSession stores a session under the hash of its token
+func (s *Store) SaveSession(tokenHash string, session models.Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(tokenHash), data)
+	})
+}
+
+// GetSession returns the session stored under the token hash, or ErrNotFound
+func (s *Store) GetSession(tokenHash string) (models.Session, error) {
+	var session models.Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return getJSON(tx.Bucket(sessionsBucket), []byte(tokenHash), &session)
+	})
+	return session, err
+}
+
+// DeleteSession removes the session stored under the token hash
+func (s *Store) DeleteSession(tokenHash string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(tokenHash))
+	})
+}
+
+// getJSON decodes the value stored under key, or returns ErrNotFound
+func getJSON(b *bolt.Bucket, key []byte, v interface{}) error {
+	data := b.Get(key)
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"uploader/internal/models"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "uploader.db"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestCreateFirstUserOnlyOnce(t *testing.T) {
+	s := openTestStore(t)
+
+	// Concurrent first registrations must not both see an empty database
+	const attempts = 10
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- s.CreateFirstUser(models.User{ID: fmt.Sprintf("id%d", i), Username: fmt.Sprintf("user%d", i)})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrUsersExist):
+			t.Errorf("CreateFirstUser: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d first users were created, want 1", created)
+	}
+	if n, _ := s.CountUsers(); n != 1 {
+		t.Errorf("CountUsers = %d, want 1", n)
+	}
+
+	// Further accounts are added with CreateUser
+	if err := s.CreateUser(models.User{ID: "other", Username: "other"}); err != nil {
+		t.Errorf("CreateUser after the first user: %v", err)
+	}
+}
+
+func TestCreateUserUsernameTaken(t *testing.T) {
+	s := openTestStore(t)
+	if err := s.CreateFirstUser(models.User{ID: "a", Username: "Alice"}); err != nil {
+		t.Fatalf("CreateFirstUser: %v", err)
+	}
+	if err := s.CreateUser(models.User{ID: "b", Username: "alice"}); !errors.Is(err, ErrUsernameTaken) {
+		t.Errorf("CreateUser with a taken username: got %v, want ErrUsernameTaken", err)
+	}
+
+	user, err := s.GetUserByUsername("ALICE")
+	if err != nil || user.ID != "a" {
+		t.Errorf("GetUserByUsername = %+v, %v", user, err)
+	}
+}
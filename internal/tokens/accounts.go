@@ -0,0 +1,103 @@
+package tokens
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"uploader/internal/models"
+)
+
+const (
+	youtubeChannelsURL = "https://www.googleapis.com/youtube/v3/channels?part=snippet&mine=true"
+	instagramMeURL     = "https://graph.instagram.com/me?fields=id,username"
+	tiktokUserInfoURL  = "https://open.tiktokapis.com/v2/user/info/?fields=open_id,display_name"
+)
+
+// Identify looks up the platform account a freshly obtained token belongs
+// to and records its ID and display name on the token
+func (m *Manager) Identify(ctx context.Context, platform string, tok *models.Token) error {
+	var err error
+	switch platform {
+	case "youtube":
+		err = m.identifyYouTube(ctx, tok)
+	case "instagram":
+		err = m.identifyInstagram(ctx, tok)
+	case "tiktok":
+		err = m.identifyTikTok(ctx, tok)
+	default:
+		err = fmt.Errorf("unknown platform %q", platform)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up %s account: %w", platform, err)
+	}
+	if tok.UserID == "" {
+		return fmt.Errorf("%s did not return an account ID", platform)
+	}
+	return nil
+}
+
+// identifyYouTube uses the channel of the signed-in Google account
+func (m *Manager) identifyYouTube(ctx context.Context, tok *models.Token) error {
+	var resp struct {
+		Items []struct {
+			ID      string `json:"id"`
+			Snippet struct {
+				Title string `json:"title"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := m.get(ctx, youtubeChannelsURL, tok, &resp); err != nil {
+		return err
+	}
+	if len(resp.Items) == 0 {
+		return fmt.Errorf("this Google account has no YouTube channel")
+	}
+	tok.UserID = resp.Items[0].ID
+	tok.Name = resp.Items[0].Snippet.Title
+	return nil
+}
+
+// identifyInstagram uses the Instagram user the token was issued for
+func (m *Manager) identifyInstagram(ctx context.Context, tok *models.Token) error {
+	var resp struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	}
+	if err := m.get(ctx, instagramMeURL, tok, &resp); err != nil {
+		return err
+	}
+	tok.UserID = resp.ID
+	tok.Name = resp.Username
+	return nil
+}
+
+// identifyTikTok looks up the display name of the open_id from the token response
+func (m *Manager) identifyTikTok(ctx context.Context, tok *models.Token) error {
+	var resp struct {
+		Data struct {
+			User struct {
+				OpenID      string `json:"open_id"`
+				DisplayName string `json:"display_name"`
+			} `json:"user"`
+		} `json:"data"`
+	}
+	if err := m.get(ctx, tiktokUserInfoURL, tok, &resp); err != nil {
+		return err
+	}
+	if resp.Data.User.OpenID != "" {
+		tok.UserID = resp.Data.User.OpenID
+	}
+	tok.Name = resp.Data.User.DisplayName
+	return nil
+}
+
+// get sends an authorized GET request and decodes the JSON response into v
+func (m *Manager) get(ctx context.Context, url string, tok *models.Token, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+	return m.do(req, v)
+}
@@ -0,0 +1,190 @@
+package tokens
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"uploader/internal/models"
+)
+
+// KeySize is the length in bytes of the AES-256 key used by FileStore
+const KeySize = 32
+
+// FileStore keeps each token in its own file, laid out as
+// dir/<user>/<platform>/<account>.enc and encrypted with AES-GCM
+type FileStore struct {
+	dir  string
+	aead cipher.AEAD
+}
+
+// NewFileStore returns a store that writes encrypted token files to dir
+func NewFileStore(dir string, key []byte) (*FileStore, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("token encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create token directory '%s': %w", dir, err)
+	}
+	return &FileStore{dir: dir, aead: aead}, nil
+}
+
+// ParseKey decodes a base64 encoded token encryption key
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("token encryption key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("token encryption key must decode to %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Get implements Store
+func (s *FileStore) Get(userID, platform, accountID string) (*models.Token, error) {
+	path, err := s.path(userID, platform, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return s.read(path, userID, platform, accountID)
+}
+
+// Put implements Store
+func (s *FileStore) Put(userID, platform, accountID string, tok *models.Token) error {
+	path, err := s.path(userID, platform, accountID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s token: %w", platform, err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, data, additionalData(userID, platform, accountID))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create token directory: %w", err)
+	}
+	// Write to a temporary file first so a crash never leaves a half-written token
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+		return fmt.Errorf("failed to write %s token: %w", platform, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s token: %w", platform, err)
+	}
+	return nil
+}
+
+// Delete implements Store
+func (s *FileStore) Delete(userID, platform, accountID string) error {
+	path, err := s.path(userID, platform, accountID)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s token: %w", platform, err)
+	}
+	return nil
+}
+
+// List implements Store
+func (s *FileStore) List(userID, platform string) ([]*models.Token, error) {
+	if !validName(userID) || !validName(platform) {
+		return nil, fmt.Errorf("invalid user %q or platform %q", userID, platform)
+	}
+	entries, err := os.ReadDir(filepath.Join(s.dir, userID, platform))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s tokens: %w", platform, err)
+	}
+
+	var tokens []*models.Token
+	for _, entry := range entries {
+		accountID, ok := strings.CutSuffix(entry.Name(), tokenExt)
+		if entry.IsDir() || !ok {
+			continue
+		}
+		tok, err := s.read(filepath.Join(s.dir, userID, platform, entry.Name()), userID, platform, accountID)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+// read decrypts the token file at path
+func (s *FileStore) read(path, userID, platform, accountID string) (*models.Token, error) {
+	sealed, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotConnected
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s token: %w", platform, err)
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("%s token file is corrupt", platform)
+	}
+	// The owner, platform and account are bound as additional data so files can't be swapped
+	data, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData(userID, platform, accountID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s token (wrong key?): %w", platform, err)
+	}
+
+	var tok models.Token
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return nil, fmt.Errorf("failed to parse %s token: %w", platform, err)
+	}
+	return &tok, nil
+}
+
+// tokenExt is the file extension of encrypted token files
+const tokenExt = ".enc"
+
+// path returns the encrypted token file for a user's platform account
+func (s *FileStore) path(userID, platform, accountID string) (string, error) {
+	if !validName(userID) || !validName(platform) || !validName(accountID) {
+		return "", fmt.Errorf("invalid %s account %q for user %q", platform, accountID, userID)
+	}
+	return filepath.Join(s.dir, userID, platform, accountID+tokenExt), nil
+}
+
+// validName reports whether s can safely be used as a single path element
+func validName(s string) bool {
+	return s != "" && !strings.ContainsAny(s, `/\.`)
+}
+
+// additionalData returns the value authenticated alongside a token
+func additionalData(userID, platform, accountID string) []byte {
+	return []byte(userID + "/" + platform + "/" + accountID)
+}
@@ -0,0 +1,143 @@
+package tokens
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"uploader/internal/models"
+)
+
+func newTestFileStore(t *testing.T, dir string, fill byte) *FileStore {
+	t.Helper()
+	s, err := NewFileStore(dir, bytes.Repeat([]byte{fill}, KeySize))
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	return s
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, newTestFileStore(t, t.TempDir(), 1))
+}
+
+func TestFileStoreEncryptsTokens(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestFileStore(t, dir, 1)
+	if err := s.Put("alice", "youtube", "chan1", &models.Token{AccessToken: "secret-access-token", UserID: "chan1"}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "alice", "youtube", "chan1.enc"))
+	if err != nil {
+		t.Fatalf("token file not written: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret-access-token")) {
+		t.Error("token file contains the access token in plain text")
+	}
+}
+
+func TestFileStoreRejectsTamperedToken(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestFileStore(t, dir, 1)
+	s.Put("alice", "youtube", "chan1", &models.Token{AccessToken: "a1", UserID: "chan1"})
+
+	path := filepath.Join(dir, "alice", "youtube", "chan1.enc")
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0600)
+
+	if _, err := s.Get("alice", "youtube", "chan1"); err == nil {
+		t.Error("Get of a tampered token succeeded")
+	}
+
+	// Files too short to hold a nonce are rejected too
+	os.WriteFile(path, []byte("x"), 0600)
+	if _, err := s.Get("alice", "youtube", "chan1"); err == nil {
+		t.Error("Get of a truncated token succeeded")
+	}
+}
+
+func TestFileStoreRejectsMovedToken(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestFileStore(t, dir, 1)
+	s.Put("alice", "youtube", "chan1", &models.Token{AccessToken: "a1", UserID: "chan1"})
+
+	// A token file copied to another user or account fails the additional data check
+	data, _ := os.ReadFile(filepath.Join(dir, "alice", "youtube", "chan1.enc"))
+	for _, dst := range []string{
+		filepath.Join(dir, "mallory", "youtube", "chan1.enc"),
+		filepath.Join(dir, "alice", "youtube", "chan2.enc"),
+		filepath.Join(dir, "alice", "tiktok", "chan1.enc"),
+	} {
+		os.MkdirAll(filepath.Dir(dst), 0700)
+		os.WriteFile(dst, data, 0600)
+	}
+
+	if _, err := s.Get("mallory", "youtube", "chan1"); err == nil {
+		t.Error("token moved to another user was accepted")
+	}
+	if _, err := s.Get("alice", "youtube", "chan2"); err == nil {
+		t.Error("token moved to another account was accepted")
+	}
+	if _, err := s.Get("alice", "tiktok", "chan1"); err == nil {
+		t.Error("token moved to another platform was accepted")
+	}
+}
+
+func TestFileStoreRejectsWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	newTestFileStore(t, dir, 1).Put("alice", "youtube", "chan1", &models.Token{AccessToken: "a1", UserID: "chan1"})
+
+	if _, err := newTestFileStore(t, dir, 2).Get("alice", "youtube", "chan1"); err == nil {
+		t.Error("Get with a different key succeeded")
+	}
+}
+
+func TestFileStoreRejectsInvalidNames(t *testing.T) {
+	s := newTestFileStore(t, t.TempDir(), 1)
+	tok := &models.Token{AccessToken: "a1", UserID: "x"}
+
+	for _, name := range []string{"", "..", "../etc", "a/b", `a\b`, "a.enc"} {
+		if err := s.Put(name, "youtube", "chan1", tok); err == nil {
+			t.Errorf("Put accepted user %q", name)
+		}
+		if err := s.Put("alice", name, "chan1", tok); err == nil {
+			t.Errorf("Put accepted platform %q", name)
+		}
+		if err := s.Put("alice", "youtube", name, tok); err == nil {
+			t.Errorf("Put accepted account %q", name)
+		}
+		if _, err := s.Get("alice", "youtube", name); err == nil {
+			t.Errorf("Get accepted account %q", name)
+		}
+		if err := s.Delete("alice", "youtube", name); err == nil {
+			t.Errorf("Delete accepted account %q", name)
+		}
+		if _, err := s.List(name, "youtube"); err == nil {
+			t.Errorf("List accepted user %q", name)
+		}
+	}
+}
+
+func TestNewFileStoreKeySize(t *testing.T) {
+	if _, err := NewFileStore(t.TempDir(), make([]byte, 16)); err == nil {
+		t.Error("NewFileStore accepted a 16 byte key")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	got, err := ParseKey(base64.StdEncoding.EncodeToString(key))
+	if err != nil || !bytes.Equal(got, key) {
+		t.Errorf("ParseKey returned %v, %v", got, err)
+	}
+
+	for _, encoded := range []string{"not base64!", base64.StdEncoding.EncodeToString(make([]byte, 16))} {
+		if _, err := ParseKey(encoded); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", encoded)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package tokens
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"uploader/internal/models"
+)
+
+const (
+	// refreshMargin is how long before expiry a token is refreshed
+	refreshMargin = 5 * time.Minute
+	// instagramRefreshMargin is larger because long-lived Instagram tokens
+	// last 60 days and can only be refreshed once they are a day old
+	instagramRefreshMargin = 7 * 24 * time.Hour
+
+	tiktokTokenURL          = "https://open.tiktokapis.com/v2/oauth/token/"
+	instagramExchangeURL    = "https://graph.instagram.com/access_token"
+	instagramRefreshURL     = "https://graph.instagram.com/refresh_access_token"
+	instagramLongLivedGrant = "ig_exchange_token"
+	instagramRefreshGrant   = "ig_refresh_token"
+)
+
+// needsRefresh reports whether the token expires within the platform's refresh margin.
+// Tokens without a known expiry are used as they are.
+func needsRefresh(platform string, tok *models.Token) bool {
+	if tok.Expiry.IsZero() {
+		return false
+	}
+	margin := refreshMargin
+	if platform == "instagram" {
+		margin = instagramRefreshMargin
+	}
+	return time.Until(tok.Expiry) < margin
+}
+
+// refreshYouTube refreshes a Google token through the oauth2 TokenSource
+func (m *Manager) refreshYouTube(ctx context.Context, tok *models.Token) (*models.Token, error) {
+	if tok.RefreshToken == "" {
+		return nil, fmt.Errorf("no refresh token saved")
+	}
+
+	// Hand the source an already expired token so it always goes to the token endpoint
+	expired := OAuth2(tok)
+	expired.Expiry = time.Now().Add(-time.Minute)
+
+	fresh, err := m.cfg.YouTubeOAuthConfig.TokenSource(ctx, expired).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := FromOAuth2(fresh)
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = tok.RefreshToken
+	}
+	if scope, ok := fresh.Extra("scope").(string); ok {
+		refreshed.Scope = scope
+	}
+	return keepAccount(refreshed, tok), nil
+}
+
+// refreshTikTok refreshes a TikTok token with the v2 refresh_token grant
+func (m *Manager) refreshTikTok(ctx context.Context, tok *models.Token) (*models.Token, error) {
+	if tok.RefreshToken == "" {
+		return nil, fmt.Errorf("no refresh token saved")
+	}
+	if !tok.RefreshExpiry.IsZero() && time.Now().After(tok.RefreshExpiry) {
+		return nil, fmt.Errorf("refresh token expired at %v", tok.RefreshExpiry)
+	}
+
+	data := url.Values{}
+	data.Set("client_key", m.cfg.TikTokOAuthConfig.ClientID)
+	data.Set("client_secret", m.cfg.TikTokOAuthConfig.ClientSecret)
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", tok.RefreshToken)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tiktokTokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var resp models.TikTokTokenResponse
+	if err := m.do(req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("no access token in refresh response")
+	}
+
+	return keepAccount(TikTokToken(resp), tok), nil
+}
+
+// refreshInstagram extends a long-lived Instagram token by another 60 days
+func (m *Manager) refreshInstagram(ctx context.Context, tok *models.Token) (*models.Token, error) {
+	params := url.Values{}
+	params.Set("grant_type", instagramRefreshGrant)
+	params.Set("access_token", tok.AccessToken)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", instagramRefreshURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refresh request: %w", err)
+	}
+
+	var resp models.InstagramLongLivedTokenResponse
+	if err := m.do(req, &resp); err != nil {
+		return nil, err
+	}
+	return keepAccount(instagramToken(resp, tok.UserID), tok), nil
+}
+
+// keepAccount copies what refresh responses do not repeat from the old
+// token to the refreshed one: the account it belongs to, its display name
+// and, if the response has none, the granted scopes
+func keepAccount(refreshed, tok *models.Token) *models.Token {
+	if refreshed.UserID == "" {
+		refreshed.UserID = tok.UserID
+	}
+	refreshed.Name = tok.Name
+	if refreshed.Scope == "" {
+		refreshed.Scope = tok.Scope
+	}
+	return refreshed
+}
+
+// ExchangeInstagram swaps a short-lived Instagram token from the OAuth
+// callback for a long-lived one that can be refreshed
+func (m *Manager) ExchangeInstagram(ctx context.Context, shortLived *models.Token) (*models.Token, error) {
+	params := url.Values{}
+	params.Set("grant_type", instagramLongLivedGrant)
+	params.Set("client_secret", m.cfg.InstagramOAuthConfig.ClientSecret)
+	params.Set("access_token", shortLived.AccessToken)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", instagramExchangeURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exchange request: %w", err)
+	}
+
+	var resp models.InstagramLongLivedTokenResponse
+	if err := m.do(req, &resp); err != nil {
+		return nil, fmt.Errorf("failed to exchange for long-lived token: %w", err)
+	}
+	return instagramToken(resp, shortLived.UserID), nil
+}
+
+// TikTokToken converts a TikTok token response into a stored token
+func TikTokToken(resp models.TikTokTokenResponse) *models.Token {
+	now := time.Now()
+	tok := &models.Token{
+		AccessToken:  resp.AccessToken,
+		TokenType:    "Bearer",
+		RefreshToken: resp.RefreshToken,
+		UserID:       resp.OpenID,
+		Scope:        resp.Scope,
+	}
+	if resp.ExpiresIn > 0 {
+		tok.Expiry = now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	if resp.RefreshExpiresIn > 0 {
+		tok.RefreshExpiry = now.Add(time.Duration(resp.RefreshExpiresIn) * time.Second)
+	}
+	return tok
+}
+
+// instagramToken converts a long-lived Instagram token response into a stored token
+func instagramToken(resp models.InstagramLongLivedTokenResponse, userID string) *models.Token {
+	tok := &models.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   resp.TokenType,
+		UserID:      userID,
+	}
+	if resp.ExpiresIn > 0 {
+		tok.Expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return tok
+}
+
+// do sends the request and decodes a JSON response into v
+func (m *Manager) do(req *http.Request, v interface{}) error {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
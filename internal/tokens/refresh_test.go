@@ -0,0 +1,75 @@
+package tokens
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"uploader/internal/config"
+	"uploader/internal/models"
+
+	"golang.org/x/oauth2"
+)
+
+func TestRefreshYouTubeKeepsAccount(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		response  string
+		wantScope string
+	}{
+		{"without scope", `{"access_token":"new","token_type":"Bearer","expires_in":3600}`, "old-scope"},
+		{"with scope", `{"access_token":"new","token_type":"Bearer","expires_in":3600,"scope":"new-scope"}`, "new-scope"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(c.response))
+			}))
+			defer srv.Close()
+
+			store := NewMemoryStore()
+			m := NewManager(&config.Config{
+				YouTubeOAuthConfig: &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: srv.URL}},
+			}, store)
+			store.Put("alice", "youtube", "chan1", &models.Token{
+				AccessToken:  "old",
+				RefreshToken: "refresh",
+				Expiry:       time.Now().Add(time.Minute),
+				UserID:       "chan1",
+				Name:         "My Channel",
+				Scope:        "old-scope",
+			})
+
+			if _, err := m.Token(context.Background(), "alice", "youtube", "chan1"); err != nil {
+				t.Fatalf("Token: %v", err)
+			}
+			saved, _ := store.Get("alice", "youtube", "chan1")
+			if saved.AccessToken != "new" {
+				t.Errorf("access token = %q, want the refreshed one", saved.AccessToken)
+			}
+			if saved.RefreshToken != "refresh" || saved.UserID != "chan1" || saved.Name != "My Channel" {
+				t.Errorf("refresh lost the account details: %+v", saved)
+			}
+			if saved.Scope != c.wantScope {
+				t.Errorf("scope = %q, want %q", saved.Scope, c.wantScope)
+			}
+		})
+	}
+}
+
+func TestKeepAccount(t *testing.T) {
+	old := &models.Token{UserID: "open1", Name: "creator", Scope: "video.publish"}
+
+	got := keepAccount(&models.Token{AccessToken: "new"}, old)
+	if got.UserID != "open1" || got.Name != "creator" || got.Scope != "video.publish" {
+		t.Errorf("keepAccount = %+v, want the old account, name and scope", got)
+	}
+
+	// What the refresh response does say wins
+	got = keepAccount(&models.Token{UserID: "open2", Scope: "user.info.basic"}, old)
+	if got.UserID != "open2" || got.Scope != "user.info.basic" || got.Name != "creator" {
+		t.Errorf("keepAccount = %+v, want the refreshed account and scope", got)
+	}
+}
@@ -0,0 +1,84 @@
+package tokens
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"uploader/internal/models"
+)
+
+// Store persists platform tokens. Every user has their own set of tokens
+// and may connect several accounts on the same platform.
+type Store interface {
+	// Get returns the user's token for a platform account, or ErrNotConnected
+	Get(userID, platform, accountID string) (*models.Token, error)
+	// Put saves the user's token for a platform account, replacing any previous one
+	Put(userID, platform, accountID string, tok *models.Token) error
+	// Delete removes the user's token for a platform account, if there is one
+	Delete(userID, platform, accountID string) error
+	// List returns the user's tokens for every connected account on the platform
+	List(userID, platform string) ([]*models.Token, error)
+}
+
+// MemoryStore keeps tokens in memory; it is intended for tests
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tokens map[string]models.Token // Keyed by userID/platform/accountID
+}
+
+// NewMemoryStore returns an empty in-memory token store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]models.Token)}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(userID, platform, accountID string) (*models.Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tok, ok := s.tokens[userID+"/"+platform+"/"+accountID]
+	if !ok {
+		return nil, ErrNotConnected
+	}
+	return &tok, nil
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(userID, platform, accountID string, tok *models.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[userID+"/"+platform+"/"+accountID] = *tok
+	return nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(userID, platform, accountID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tokens, userID+"/"+platform+"/"+accountID)
+	return nil
+}
+
+// List implements Store
+func (s *MemoryStore) List(userID, platform string) ([]*models.Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for key := range s.tokens {
+		if strings.HasPrefix(key, userID+"/"+platform+"/") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	tokens := make([]*models.Token, 0, len(keys))
+	for _, key := range keys {
+		tok := s.tokens[key]
+		tokens = append(tokens, &tok)
+	}
+	return tokens, nil
+}
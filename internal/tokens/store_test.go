@@ -0,0 +1,86 @@
+package tokens
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"uploader/internal/models"
+)
+
+// testStore checks the behaviour every Store implementation must share
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+
+	if _, err := s.Get("alice", "youtube", "chan1"); !errors.Is(err, ErrNotConnected) {
+		t.Fatalf("Get of missing token: got %v, want ErrNotConnected", err)
+	}
+	if toks, err := s.List("alice", "youtube"); err != nil || len(toks) != 0 {
+		t.Fatalf("List with no tokens: got %v, %v", toks, err)
+	}
+
+	expiry := time.Now().Add(time.Hour).Round(time.Second)
+	tok := &models.Token{AccessToken: "a1", RefreshToken: "r1", Expiry: expiry, UserID: "chan1", Name: "Channel One", Scope: "upload"}
+	if err := s.Put("alice", "youtube", "chan1", tok); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, err := s.Get("alice", "youtube", "chan1")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.AccessToken != "a1" || got.RefreshToken != "r1" || !got.Expiry.Equal(expiry) || got.Name != "Channel One" || got.Scope != "upload" {
+		t.Errorf("Get returned %+v, want %+v", got, tok)
+	}
+
+	// Tokens of other accounts, platforms and users are kept apart
+	s.Put("alice", "youtube", "chan2", &models.Token{AccessToken: "a2", UserID: "chan2"})
+	s.Put("alice", "tiktok", "chan1", &models.Token{AccessToken: "t1", UserID: "chan1"})
+	s.Put("bob", "youtube", "chan1", &models.Token{AccessToken: "b1", UserID: "chan1"})
+
+	toks, err := s.List("alice", "youtube")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(toks) != 2 {
+		t.Fatalf("List returned %d tokens, want 2", len(toks))
+	}
+	if got, _ := s.Get("bob", "youtube", "chan1"); got == nil || got.AccessToken != "b1" {
+		t.Errorf("Get of another user's token returned %+v", got)
+	}
+
+	// Put replaces the previous token
+	s.Put("alice", "youtube", "chan1", &models.Token{AccessToken: "a3", UserID: "chan1"})
+	if got, _ := s.Get("alice", "youtube", "chan1"); got == nil || got.AccessToken != "a3" {
+		t.Errorf("Get after replacing returned %+v", got)
+	}
+
+	if err := s.Delete("alice", "youtube", "chan1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get("alice", "youtube", "chan1"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Get after Delete: got %v, want ErrNotConnected", err)
+	}
+	if err := s.Delete("alice", "youtube", "chan1"); err != nil {
+		t.Errorf("Delete of missing token: %v", err)
+	}
+	if got, _ := s.Get("bob", "youtube", "chan1"); got == nil {
+		t.Errorf("Delete removed another user's token")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestMemoryStoreReturnsCopies(t *testing.T) {
+	s := NewMemoryStore()
+	tok := &models.Token{AccessToken: "a1", UserID: "chan1"}
+	s.Put("alice", "youtube", "chan1", tok)
+	tok.AccessToken = "changed"
+
+	got, _ := s.Get("alice", "youtube", "chan1")
+	got.AccessToken = "changed too"
+	if again, _ := s.Get("alice", "youtube", "chan1"); again.AccessToken != "a1" {
+		t.Errorf("stored token changed to %q through a caller's copy", again.AccessToken)
+	}
+}
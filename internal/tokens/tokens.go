@@ -0,0 +1,161 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"uploader/internal/config"
+	"uploader/internal/models"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNotConnected is returned when no token has been saved for a platform
+var ErrNotConnected = errors.New("account not connected")
+
+// refreshFunc obtains a new token for a platform from an expiring one
+type refreshFunc func(ctx context.Context, tok *models.Token) (*models.Token, error)
+
+// Manager loads platform tokens, refreshes them before they expire and
+// writes refreshed tokens back to storage
+type Manager struct {
+	cfg        *config.Config
+	store      Store
+	client     *http.Client
+	refreshers map[string]refreshFunc
+
+	mu    sync.Mutex             // Guards locks
+	locks map[string]*sync.Mutex // Per account, so a token is only refreshed once at a time
+}
+
+// NewManager returns a token manager for the configured platforms that
+// keeps tokens in store
+func NewManager(cfg *config.Config, store Store) *Manager {
+	m := &Manager{
+		cfg:    cfg,
+		store:  store,
+		client: &http.Client{Timeout: 30 * time.Second},
+		locks:  make(map[string]*sync.Mutex),
+	}
+	m.refreshers = map[string]refreshFunc{
+		"youtube":   m.refreshYouTube,
+		"instagram": m.refreshInstagram,
+		"tiktok":    m.refreshTikTok,
+	}
+	return m
+}
+
+// Token returns a usable token for one of the user's platform accounts,
+// refreshing and saving it first if it has expired or is about to
+func (m *Manager) Token(ctx context.Context, userID, platform, accountID string) (*models.Token, error) {
+	lock := m.lock(userID, platform, accountID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	tok, err := m.Load(userID, platform, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, ok := m.refreshers[platform]
+	if !ok || !needsRefresh(platform, tok) {
+		return tok, nil
+	}
+
+	log.Printf("Refreshing %s token (expires %v)", platform, tok.Expiry)
+	refreshed, err := refresh(ctx, tok)
+	if err != nil {
+		// A token that has not actually expired yet is still worth trying
+		if time.Now().Before(tok.Expiry) {
+			log.Printf("Failed to refresh %s token, using current one: %v", platform, err)
+			return tok, nil
+		}
+		return nil, fmt.Errorf("%s token has expired and could not be refreshed, please login again: %w", platform, err)
+	}
+
+	if err := m.Save(userID, platform, refreshed); err != nil {
+		log.Printf("Failed to save refreshed %s token: %v", platform, err)
+	}
+	return refreshed, nil
+}
+
+// lock returns the lock that serializes refreshes of one account's token,
+// so slow refreshes do not hold up the tokens of other accounts
+func (m *Manager) lock(userID, platform, accountID string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := userID + "/" + platform + "/" + accountID
+	lock, ok := m.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[key] = lock
+	}
+	return lock
+}
+
+// Load reads the user's saved token for a platform account without refreshing it
+func (m *Manager) Load(userID, platform, accountID string) (*models.Token, error) {
+	return m.store.Get(userID, platform, accountID)
+}
+
+// Save writes the user's token for the platform account it belongs to
+func (m *Manager) Save(userID, platform string, tok *models.Token) error {
+	if tok.UserID == "" {
+		return fmt.Errorf("%s token has no account ID", platform)
+	}
+	return m.store.Put(userID, platform, tok.UserID, tok)
+}
+
+// Delete disconnects one of the user's platform accounts
+func (m *Manager) Delete(userID, platform, accountID string) error {
+	return m.store.Delete(userID, platform, accountID)
+}
+
+// Accounts lists the accounts the user has connected on the platform
+func (m *Manager) Accounts(userID, platform string) ([]models.Account, error) {
+	toks, err := m.store.List(userID, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]models.Account, 0, len(toks))
+	for _, tok := range toks {
+		name := tok.Name
+		if name == "" {
+			name = tok.UserID
+		}
+		accounts = append(accounts, models.Account{Platform: platform, ID: tok.UserID, Name: name})
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return strings.ToLower(accounts[i].Name) < strings.ToLower(accounts[j].Name)
+	})
+	return accounts, nil
+}
+
+// OAuth2 converts a stored token for use with golang.org/x/oauth2 clients
+func OAuth2(tok *models.Token) *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  tok.AccessToken,
+		TokenType:    tok.TokenType,
+		RefreshToken: tok.RefreshToken,
+		Expiry:       tok.Expiry,
+	}
+}
+
+// FromOAuth2 converts a token obtained from a golang.org/x/oauth2 exchange
+func FromOAuth2(tok *oauth2.Token) *models.Token {
+	return &models.Token{
+		AccessToken:  tok.AccessToken,
+		TokenType:    tok.TokenType,
+		RefreshToken: tok.RefreshToken,
+		Expiry:       tok.Expiry,
+	}
+}
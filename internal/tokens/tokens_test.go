@@ -0,0 +1,127 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"uploader/internal/config"
+	"uploader/internal/models"
+)
+
+// newTestManager returns a manager on a MemoryStore whose YouTube
+// refresher is replaced by refresh
+func newTestManager(refresh refreshFunc) (*Manager, *MemoryStore) {
+	store := NewMemoryStore()
+	m := NewManager(&config.Config{}, store)
+	m.refreshers["youtube"] = refresh
+	return m, store
+}
+
+func TestTokenUsesValidToken(t *testing.T) {
+	m, store := newTestManager(func(ctx context.Context, tok *models.Token) (*models.Token, error) {
+		t.Error("refreshed a token that is not about to expire")
+		return tok, nil
+	})
+	store.Put("alice", "youtube", "chan1", &models.Token{AccessToken: "a1", UserID: "chan1", Expiry: time.Now().Add(time.Hour)})
+
+	tok, err := m.Token(context.Background(), "alice", "youtube", "chan1")
+	if err != nil || tok.AccessToken != "a1" {
+		t.Errorf("Token returned %+v, %v", tok, err)
+	}
+}
+
+func TestTokenNotConnected(t *testing.T) {
+	m, _ := newTestManager(nil)
+	if _, err := m.Token(context.Background(), "alice", "youtube", "chan1"); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Token of missing account: got %v, want ErrNotConnected", err)
+	}
+}
+
+func TestTokenRefreshesAndSaves(t *testing.T) {
+	m, store := newTestManager(func(ctx context.Context, tok *models.Token) (*models.Token, error) {
+		return &models.Token{AccessToken: "new", UserID: tok.UserID, Expiry: time.Now().Add(time.Hour)}, nil
+	})
+	store.Put("alice", "youtube", "chan1", &models.Token{AccessToken: "old", UserID: "chan1", Expiry: time.Now().Add(time.Minute)})
+
+	tok, err := m.Token(context.Background(), "alice", "youtube", "chan1")
+	if err != nil || tok.AccessToken != "new" {
+		t.Fatalf("Token returned %+v, %v", tok, err)
+	}
+	if saved, _ := store.Get("alice", "youtube", "chan1"); saved.AccessToken != "new" {
+		t.Errorf("refreshed token was not saved, store has %q", saved.AccessToken)
+	}
+}
+
+func TestTokenRefreshFailure(t *testing.T) {
+	m, store := newTestManager(func(ctx context.Context, tok *models.Token) (*models.Token, error) {
+		return nil, errors.New("refresh failed")
+	})
+
+	// A token that has not expired yet is still used
+	store.Put("alice", "youtube", "chan1", &models.Token{AccessToken: "a1", UserID: "chan1", Expiry: time.Now().Add(time.Minute)})
+	if tok, err := m.Token(context.Background(), "alice", "youtube", "chan1"); err != nil || tok.AccessToken != "a1" {
+		t.Errorf("Token of unexpired account returned %+v, %v", tok, err)
+	}
+
+	store.Put("alice", "youtube", "chan2", &models.Token{AccessToken: "a2", UserID: "chan2", Expiry: time.Now().Add(-time.Minute)})
+	if _, err := m.Token(context.Background(), "alice", "youtube", "chan2"); err == nil {
+		t.Error("Token of expired account succeeded without a refresh")
+	}
+}
+
+func TestTokenRefreshDoesNotBlockOtherAccounts(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	m, store := newTestManager(func(ctx context.Context, tok *models.Token) (*models.Token, error) {
+		close(started)
+		<-release
+		return &models.Token{AccessToken: "new", UserID: tok.UserID, Expiry: time.Now().Add(time.Hour)}, nil
+	})
+	store.Put("alice", "youtube", "slow", &models.Token{AccessToken: "a1", UserID: "slow", Expiry: time.Now().Add(-time.Minute)})
+	store.Put("alice", "youtube", "fast", &models.Token{AccessToken: "a2", UserID: "fast", Expiry: time.Now().Add(time.Hour)})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Token(context.Background(), "alice", "youtube", "slow")
+	}()
+	<-started
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := m.Token(context.Background(), "alice", "youtube", "fast")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Errorf("Token of other account: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Token of other account waited for an unrelated refresh")
+	}
+	close(release)
+	<-done
+}
+
+func TestAccounts(t *testing.T) {
+	m, store := newTestManager(nil)
+	store.Put("alice", "youtube", "c1", &models.Token{UserID: "c1", Name: "zebra"})
+	store.Put("alice", "youtube", "c2", &models.Token{UserID: "c2", Name: "Apple"})
+	store.Put("alice", "youtube", "c3", &models.Token{UserID: "c3"})
+
+	accounts, err := m.Accounts("alice", "youtube")
+	if err != nil {
+		t.Fatalf("Accounts: %v", err)
+	}
+	var names []string
+	for _, a := range accounts {
+		names = append(names, a.Name)
+	}
+	// Sorted case-insensitively, falling back to the account ID without a name
+	want := []string{"Apple", "c3", "zebra"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Errorf("Accounts names = %v, want %v", names, want)
+	}
+}